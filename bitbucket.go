@@ -0,0 +1,206 @@
+package castor
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// bitbucket implements Provider using the REST API (1.0) of Bitbucket Server.
+type bitbucket struct {
+	api   string
+	repo  repository
+	token string
+}
+
+func newBitbucket(repo repository, conf Conf) Provider {
+	host := conf.host(repo.host)
+	api := host.API
+	if api == "" {
+		api = "https://" + repo.host + "/rest/api/1.0"
+	}
+
+	return &bitbucket{api: strings.TrimSuffix(api, "/"), repo: repo, token: host.Token}
+}
+
+type bbUser struct {
+	User struct {
+		Name string `json:"name"`
+	} `json:"user"`
+}
+
+type bbReviewer struct {
+	bbUser
+	// Status is one of APPROVED, NEEDS_WORK or UNAPPROVED.
+	Status string `json:"status"`
+}
+
+type bbRef struct {
+	DisplayID  string `json:"displayId"`
+	Repository struct {
+		Slug    string `json:"slug"`
+		Project struct {
+			Key string `json:"key"`
+		} `json:"project"`
+	} `json:"repository"`
+}
+
+type bbPR struct {
+	ID        int          `json:"id"`
+	Title     string       `json:"title"`
+	State     string       `json:"state"`
+	Author    bbUser       `json:"author"`
+	Reviewers []bbReviewer `json:"reviewers"`
+	FromRef   bbRef        `json:"fromRef"`
+	ToRef     bbRef        `json:"toRef"`
+	Links     struct {
+		Self []struct {
+			Href string `json:"href"`
+		} `json:"self"`
+	} `json:"links"`
+	// CreatedDate and UpdatedDate are in milliseconds since epoch.
+	CreatedDate int64 `json:"createdDate"`
+	UpdatedDate int64 `json:"updatedDate"`
+}
+
+type bbPage struct {
+	Values        []bbPR `json:"values"`
+	IsLastPage    bool   `json:"isLastPage"`
+	NextPageStart int    `json:"nextPageStart"`
+}
+
+func (bb *bitbucket) SearchPRs(conf Conf, page func(PRsSearch) error) error {
+	q := url.Values{"state": {bbState(conf)}, "limit": {"100"}}
+
+	// Bitbucket has no search across repos, the dashboard lists the PRs
+	// of the owner of the token instead.
+	endpoint := bb.repoPath() + "/pull-requests"
+	if conf.All {
+		endpoint = "/dashboard/pull-requests"
+	} else if !conf.Everyone {
+		q.Set("username.1", conf.User)
+	}
+
+	// Bitbucket doesn't count the PRs, so all the pages are read to count
+	// them, even past conf.Limit
+	shown, count := 0, 0
+	for start := 0; ; {
+		q.Set("start", strconv.Itoa(start))
+
+		var res bbPage
+		if err := bb.get(endpoint+"?"+q.Encode(), &res); err != nil {
+			return err
+		}
+
+		search := PRsSearch{}
+		for _, pr := range res.Values {
+			if conf.Closed && !conf.Open && pr.State == "OPEN" {
+				continue
+			}
+			count++
+			if conf.Limit > 0 && shown >= conf.Limit {
+				continue
+			}
+			search.Nodes = append(search.Nodes, pr.toPR())
+			shown++
+		}
+		search.IssueCount = count
+
+		if err := page(search); err != nil {
+			return err
+		}
+		if res.IsLastPage || len(res.Values) == 0 {
+			return nil
+		}
+		start = res.NextPageStart
+	}
+}
+
+// bbState maps the --open and --closed flags to the `state` of Bitbucket
+// PRs, closed PRs are either DECLINED or MERGED so those are filtered
+// afterwards.
+func bbState(conf Conf) string {
+	if conf.Open && !conf.Closed {
+		return "OPEN"
+	}
+	return "ALL"
+}
+
+func (bb *bitbucket) PRRefs(n int) (string, string, error) {
+	pr, err := bb.pr(n)
+	if err != nil {
+		return "", "", err
+	}
+
+	return pr.ToRef.DisplayID, pr.FromRef.DisplayID, nil
+}
+
+func (bb *bitbucket) PR(n int) (SearchPR, error) {
+	pr, err := bb.pr(n)
+	if err != nil {
+		return SearchPR{}, err
+	}
+
+	return pr.toPR(), nil
+}
+
+func (bb *bitbucket) HeadRef(n int) string {
+	return fmt.Sprintf("refs/pull-requests/%d/from", n)
+}
+
+func (bb *bitbucket) pr(n int) (bbPR, error) {
+	var pr bbPR
+	err := bb.get(fmt.Sprintf("%s/pull-requests/%d", bb.repoPath(), n), &pr)
+	return pr, err
+}
+
+// toPR maps a Bitbucket PR into a PR, its review requests are the reviewers
+// that haven't approved it yet. Bitbucket Server PRs don't have labels.
+func (pr bbPR) toPR() SearchPR {
+	p := SearchPR{
+		Number:      pr.ID,
+		Title:       pr.Title,
+		Author:      WithLogin{pr.Author.User.Name},
+		HeadRefName: pr.FromRef.DisplayID,
+		BaseRefName: pr.ToRef.DisplayID,
+		Closed:      pr.State != "OPEN",
+		Merged:      pr.State == "MERGED",
+		CreatedAt:   time.Unix(0, pr.CreatedDate*int64(time.Millisecond)),
+		UpdatedAt:   time.Unix(0, pr.UpdatedDate*int64(time.Millisecond)),
+	}
+	if len(pr.Links.Self) > 0 {
+		p.URL = pr.Links.Self[0].Href
+	}
+	p.HeadRepository.Name = pr.FromRef.Repository.Slug
+	p.HeadRepositoryOwner.Login = pr.FromRef.Repository.Project.Key
+
+	for _, r := range pr.Reviewers {
+		if r.Status == "APPROVED" {
+			continue
+		}
+		reviewer := RequestedReviewer{RequestedReviewer: LoginAndName{Login: r.User.Name}}
+		if r.Status == "NEEDS_WORK" {
+			reviewer.State = "needs work"
+		}
+		p.ReviewRequests.Nodes = append(p.ReviewRequests.Nodes, reviewer)
+	}
+	p.ReviewRequests.TotalCount = len(p.ReviewRequests.Nodes)
+
+	return p
+}
+
+func (bb *bitbucket) repoPath() string {
+	return fmt.Sprintf("/projects/%s/repos/%s", bb.repo.owner, bb.repo.name)
+}
+
+func (bb *bitbucket) get(endpoint string, v interface{}) error {
+	header := http.Header{}
+	if bb.token != "" {
+		header.Set("Authorization", "Bearer "+bb.token)
+	}
+
+	return getJSON(bb.api+endpoint, header, v)
+}
@@ -0,0 +1,28 @@
+package castor
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBitbucketReviewers(t *testing.T) {
+	var pr bbPR
+	for _, r := range []struct{ name, status string }{
+		{"ann", "APPROVED"},
+		{"bob", "NEEDS_WORK"},
+		{"cid", "UNAPPROVED"},
+	} {
+		reviewer := bbReviewer{Status: r.status}
+		reviewer.User.Name = r.name
+		pr.Reviewers = append(pr.Reviewers, reviewer)
+	}
+
+	p := pr.toPR()
+	if got, want := reviewers(p), []string{"bob", "cid"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got reviewers %q, want %q", got, want)
+	}
+	if got := prsColumns["reviews"](p); !strings.HasSuffix(got, "(bob (needs work), cid)") {
+		t.Errorf("got reviews %q, want bob needing work", got)
+	}
+}
@@ -0,0 +1,113 @@
+package castor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// prsCache is the last listing of a PRs search, stored under the user cache
+// dir (e.g. ~/.cache/castor) in a file per search.
+type prsCache struct {
+	FetchedAt time.Time `json:"fetchedAt"`
+	Search    PRsSearch `json:"search"`
+}
+
+// age returns how long ago the cached PRs were fetched.
+func (c prsCache) age() time.Duration {
+	return time.Since(c.FetchedAt).Round(time.Second)
+}
+
+// upToDate tells if none of the cached PRs changed and no PR was added to the
+// search since they were cached, for the providers that can tell it without
+// listing the PRs again (GitHub and GitLab). The PRs updated since the newest
+// cached one must be cached as they are, comparing the update times of the
+// host instead of the local clock.
+func (c prsCache) upToDate(provider Provider, conf Conf) bool {
+	lister, ok := provider.(updateLister)
+	if !ok || len(c.Search.Nodes) == 0 {
+		return false
+	}
+
+	var newest time.Time
+	cached := map[string]time.Time{}
+	for _, pr := range c.Search.Nodes {
+		if pr.UpdatedAt.After(newest) {
+			newest = pr.UpdatedAt
+		}
+		cached[pr.URL] = pr.UpdatedAt
+	}
+
+	updated, ok, err := lister.updatedSince(conf, newest)
+	if err != nil || !ok {
+		return false
+	}
+	for _, pr := range updated {
+		if t, ok := cached[pr.URL]; !ok || !t.Equal(pr.UpdatedAt) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// cacheKey identifies a PRs search by the repo and the filters used.
+func cacheKey(repo repository, conf Conf) string {
+	b, _ := json.Marshal([]interface{}{
+		repo.host, repo.path,
+		conf.All, conf.Everyone, conf.Closed, conf.Open, conf.User, conf.Limit,
+	})
+	sum := sha256.Sum256(b)
+
+	return hex.EncodeToString(sum[:])
+}
+
+func cachePath(key string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "castor", key+".json"), nil
+}
+
+func readCache(key string) (prsCache, bool) {
+	path, err := cachePath(key)
+	if err != nil {
+		return prsCache{}, false
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return prsCache{}, false
+	}
+
+	var c prsCache
+	if err := json.Unmarshal(b, &c); err != nil {
+		return prsCache{}, false
+	}
+
+	return c, true
+}
+
+func writeCache(key string, search PRsSearch) error {
+	path, err := cachePath(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(prsCache{FetchedAt: time.Now(), Search: search})
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, b, 0600)
+}
@@ -5,32 +5,144 @@ import (
 	"os"
 	"strconv"
 	"strings"
-	"text/tabwriter"
+	"time"
 
 	"github.com/aybabtme/rgbterm"
+	"github.com/fatih/color"
 	"github.com/lucasb-eyer/go-colorful"
 )
 
 // Conf holds the configuration for listing PRs.
 type Conf struct {
-	All       bool   `json:"-"`
-	Everyone  bool   `json:"-"`
-	Closed    bool   `json:"-"`
-	Open      bool   `json:"-"`
-	ShowStats bool   `json:"-"`
-	Remote    string `json:"-"`
-	Token     string `json:"token,omitempty"`
-	User      string `json:"user,omitempty"`
+	All       bool          `json:"-"`
+	Everyone  bool          `json:"-"`
+	Closed    bool          `json:"-"`
+	Open      bool          `json:"-"`
+	ShowStats bool          `json:"-"`
+	Output    string        `json:"-"`
+	Format    string        `json:"-"`
+	Columns   []string      `json:"-"`
+	Sort      string        `json:"-"`
+	GroupBy   string        `json:"-"`
+	Limit     int           `json:"-"`
+	Offline   bool          `json:"-"`
+	MaxAge    time.Duration `json:"-"`
+	Worktree  bool          `json:"-"`
+	Remote    string        `json:"-"`
+	Token     string        `json:"token,omitempty"`
+	User      string        `json:"user,omitempty"`
+	// ForceWorktree reviews in a temporary worktree when the repo is in the
+	// middle of an operation (e.g. a rebase) instead of refusing to review.
+	ForceWorktree bool `json:"-"`
+	// Worktrees is the dir `castor review --worktree` creates worktrees in.
+	Worktrees string `json:"worktrees,omitempty"`
+	// LFS is how `castor review` fetches Git LFS objects: `changed` (the
+	// default) for the files changed by the PR, `all` or `off`.
+	LFS string `json:"lfs,omitempty"`
+	// LFSFlag is the `--lfs` of `castor review`, it takes precedence over
+	// the `castor.lfs` git config of the repo, which does over LFS.
+	LFSFlag string `json:"-"`
+	// Templates are the named templates `castor prs --format` accepts.
+	Templates map[string]string `json:"templates,omitempty"`
+	// Hosts holds the configuration of code hosts other than github.com
+	// (e.g. GitHub Enterprise instances), keyed by the host of the remote.
+	Hosts map[string]HostConf `json:"hosts,omitempty"`
 }
 
-// List lists PRs
+// HostConf holds the configuration for a single code host.
+type HostConf struct {
+	// Provider is the kind of host (e.g. github or gitlab), guessed from
+	// the host name when empty.
+	Provider string `json:"provider,omitempty"`
+	// API is the API endpoint of the host, derived from the host when empty.
+	API   string `json:"api,omitempty"`
+	Token string `json:"token,omitempty"`
+}
+
+// host returns the configuration for host. The global token is a github.com
+// token, so only github.com falls back to it, sending it to other hosts would
+// leak it.
+func (conf Conf) host(host string) HostConf {
+	h := conf.Hosts[host]
+	if h.Token == "" && (host == "" || host == "github.com") {
+		h.Token = conf.Token
+	}
+	return h
+}
+
+// List lists PRs, caching the listing to show it with `--offline`, when it's
+// not older than `--max-age`, when the provider tells none of its PRs changed
+// or when the provider can't be reached.
 func List(conf Conf) error {
-	prs, err := fetchPRs(conf)
+	repo, err := remoteRepository(conf.Remote)
+	if err != nil {
+		return ExitErr(1, err)
+	}
+
+	key := cacheKey(repo, conf)
+	cache, cached := readCache(key)
+	out, err := newPRsOutput(conf)
+	if err != nil {
+		return ExitErr(1, err)
+	}
+
+	switch {
+	case conf.Offline && !cached:
+		return ExitErrorF(1, "There are no cached PRs for this search, run it once without --offline")
+	case conf.Offline, cached && conf.MaxAge > 0 && cache.age() <= conf.MaxAge:
+		return listCached(out, cache)
+	}
+
+	provider, err := newProvider(conf)
 	if err != nil {
 		return ExitErr(1, err)
 	}
 
-	printPRsList(prs.IssueCount, prs.Nodes, conf)
+	if cached && cache.upToDate(provider, conf) {
+		if err := writeCache(key, cache.Search); err != nil {
+			fmt.Fprintf(os.Stderr, "Couldn't cache PRs: %s\n\n", err)
+		}
+		return listSearch(out, cache.Search)
+	}
+
+	var search PRsSearch
+	err = provider.SearchPRs(conf, func(page PRsSearch) error {
+		search.IssueCount = page.IssueCount
+		search.Nodes = append(search.Nodes, page.Nodes...)
+		return out.page(page)
+	})
+	if err != nil && cached && out.shown == 0 {
+		fmt.Fprintf(os.Stderr, "Couldn't fetch PRs: %s\n\n", err)
+		return listCached(out, cache)
+	}
+	if err != nil {
+		return ExitErr(1, err)
+	}
+
+	if err := out.footer(); err != nil {
+		return ExitErr(1, err)
+	}
+
+	if err := writeCache(key, search); err != nil {
+		fmt.Fprintf(os.Stderr, "\nCouldn't cache PRs: %s\n", err)
+	}
+
+	return nil
+}
+
+func listCached(out *prsOutput, cache prsCache) error {
+	fmt.Fprintf(os.Stderr, "Showing PRs cached %s ago\n\n", cache.age())
+
+	return listSearch(out, cache.Search)
+}
+
+func listSearch(out *prsOutput, search PRsSearch) error {
+	if err := out.page(search); err != nil {
+		return ExitErr(1, err)
+	}
+	if err := out.footer(); err != nil {
+		return ExitErr(1, err)
+	}
 
 	return nil
 }
@@ -43,12 +155,64 @@ func ReviewPR(n string, conf Conf) error {
 		return ExitErrorF(1, "'%s' is not a number", n)
 	}
 
-	base, head, err := getPRRefs(prNum, conf)
+	// switching branches mid rebase, merge, etc. could break it, so it's
+	// checked before asking the API for the PR
+	if !conf.Worktree && isRepo() {
+		status, err := git.Status()
+		if err != nil {
+			return ExitErr(1, err)
+		}
+		if err := checkSafe(status); err != nil {
+			if !conf.ForceWorktree {
+				return ExitErrorF(1, "%s\nOr run `castor review %d --force-worktree` to review it in a temporary worktree", err, prNum)
+			}
+			fmt.Printf("A %s is in progress, reviewing PR #%d in a temporary worktree\n\n", status.Operation, prNum)
+			conf.Worktree = true
+			conf.Worktrees = tempWorktrees()
+		}
+	}
+
+	provider, err := newProvider(conf)
+	if err != nil {
+		return ExitErr(1, err)
+	}
+
+	base, head, err := provider.PRRefs(prNum)
 	if err != nil {
 		return ExitErr(1, err)
 	}
 
-	err = switchToBranch(base, head, conf)
+	if conf.Worktree {
+		err = reviewInWorktree(prNum, base, head, provider.HeadRef(prNum), conf)
+	} else {
+		err = switchToBranch(prNum, base, head, provider.HeadRef(prNum), conf)
+	}
+	if err != nil {
+		return ExitErr(1, err)
+	}
+
+	return nil
+}
+
+// RemoveWorktree removes the worktree `castor review --worktree` created for a PR.
+func RemoveWorktree(n string, conf Conf) error {
+	prNum, err := strconv.Atoi(n)
+	if err != nil {
+		return ExitErrorF(1, "'%s' is not a number", n)
+	}
+
+	err = removeWorktree(prNum, conf)
+	if err != nil {
+		return ExitErr(1, err)
+	}
+
+	return nil
+}
+
+// Clean deletes the branches castor fetched PRs into.
+func Clean() error {
+	err := cleanPRBranches()
+
 	if err != nil {
 		return ExitErr(1, err)
 	}
@@ -67,81 +231,318 @@ func GoBack(branch string) error {
 	return nil
 }
 
-// TODO: don't print status if all open (`--closed` could be merged/closed)
-func printPRsList(count int, prs []SearchPR, conf Conf) {
-	if count == 0 {
-		return
+// ContinueBack finishes going back once the conflicts recovering the Work In
+// Progress are resolved.
+func ContinueBack() error {
+	err := continueRestore()
+
+	if err != nil {
+		return ExitErr(1, err)
 	}
-	w := new(tabwriter.Writer)
-	w.Init(os.Stdout, 5, 2, 1, ' ', tabwriter.Debug)
-	switch {
-	case conf.All:
-		fmt.Fprintln(w, " PR\t REPO\t TITLE\t BRANCH\t AUTHOR\t STATUS\t REVIEWS\t LABELS")
-	default:
-		fmt.Fprintln(w, " PR\t TITLE\t BRANCH\t AUTHOR\t STATUS\t REVIEWS\t LABELS")
-	}
-
-	for _, pr := range prs {
-		var reviews string
-		if pr.ReviewRequests.TotalCount > 0 {
-			rev := "reviews"
-			if pr.ReviewRequests.TotalCount == 1 {
-				rev = "review "
-			}
-			var reviewers string
-			for i, r := range pr.ReviewRequests.Nodes {
-				reviewer := r.RequestedReviewer.Login
-				if reviewer == "" {
-					reviewer = r.RequestedReviewer.Name
-				}
-				if i == 0 {
-					reviewers += reviewer
-				} else {
-					reviewers += ", " + reviewer
-				}
+
+	return nil
+}
+
+// AbortBack undoes recovering a Work In Progress that had conflicts.
+func AbortBack() error {
+	err := abortRestore()
+
+	if err != nil {
+		return ExitErr(1, err)
+	}
+
+	return nil
+}
+
+// GoBackAll unwinds all the nested reviews, going back to the branch the
+// first one was started from.
+func GoBackAll() error {
+	err := goBackAll()
+
+	if err != nil {
+		return ExitErr(1, err)
+	}
+
+	return nil
+}
+
+// Sessions prints the Work In Progress castor saved in the current repository.
+func Sessions() error {
+	err := printSessions()
+
+	if err != nil {
+		return ExitErr(1, err)
+	}
+
+	return nil
+}
+
+// ShowSession prints the diff of a Work In Progress castor saved, identified by
+// PR number or stash SHA.
+func ShowSession(id string) error {
+	err := showSession(id)
+
+	if err != nil {
+		return ExitErr(1, err)
+	}
+
+	return nil
+}
+
+// DropSession drops a Work In Progress castor saved, identified by PR number
+// or stash SHA, asking for confirmation unless yes is true.
+func DropSession(id string, yes bool) error {
+	err := dropSession(id, yes)
+
+	if err != nil {
+		return ExitErr(1, err)
+	}
+
+	return nil
+}
+
+// Status prints the state of the current repository and the Work In Progress
+// castor saved in it.
+func Status() error {
+	if !isRepo() {
+		return ExitErrorF(1, "Not a git repository")
+	}
+
+	status, err := git.Status()
+	if err != nil {
+		return ExitErr(1, err)
+	}
+	if status.Detached {
+		fmt.Printf("HEAD is detached at %.7s\n", status.Head)
+	}
+	if status.Operation != "" {
+		fmt.Printf("A %s is in progress, castor won't switch branches until it's done\n", status.Operation)
+	}
+	if status.Sparse {
+		fmt.Println("This is a sparse checkout")
+	}
+
+	wip, ok, err := findSession("")
+	if err != nil {
+		return ExitErr(1, err)
+	}
+	if !ok {
+		fmt.Println("Castor didn't save any Work In Progress in this repository")
+		return nil
+	}
+
+	fmt.Printf("Reviewing PR #%d since %s\n", wip.PR, wip.StartedAt.Format("2006-01-02 15:04"))
+	if wip.Clean {
+		fmt.Printf("Started from branch `%s`, which was clean\n", wip.Branch)
+	} else {
+		fmt.Printf("Work In Progress of branch `%s` saved in stash %.7s\n", wip.Branch, wip.Stash)
+	}
+	return nil
+}
+
+// APIStatus prints the status of the API rate limit of the provider of the
+// current repository.
+func APIStatus(conf Conf) error {
+	provider, err := newProvider(conf)
+	if err != nil {
+		return ExitErr(1, err)
+	}
+
+	limiter, ok := provider.(rateLimiter)
+	if !ok {
+		return ExitErrorF(1, "The provider of this repository doesn't report its API status")
+	}
+
+	rl, err := limiter.RateLimit()
+	if err != nil {
+		return ExitErr(1, err)
+	}
+
+	fmt.Printf("User:       %s\n", rl.User)
+	fmt.Printf("Remaining:  %d of %d points\n", rl.Remaining, rl.Limit)
+	fmt.Printf("Resets at:  %s (in %s)\n", rl.ResetAt.Local().Format("15:04:05"), time.Until(rl.ResetAt).Round(time.Second))
+
+	return nil
+}
+
+// prsColumns maps the columns `castor prs --columns` accepts to their values.
+var prsColumns = map[string]func(pr SearchPR) string{
+	"pr": func(pr SearchPR) string {
+		return strconv.Itoa(pr.Number)
+	},
+	"repo": func(pr SearchPR) string {
+		return pr.HeadRepositoryOwner.Login + "/" + pr.HeadRepository.Name
+	},
+	"title": func(pr SearchPR) string {
+		return truncate(pr.Title, 30)
+	},
+	"branch": func(pr SearchPR) string {
+		return truncate(pr.HeadRefName, 30)
+	},
+	"base": func(pr SearchPR) string {
+		return truncate(pr.BaseRefName, 30)
+	},
+	"author": func(pr SearchPR) string {
+		return pr.Author.Login
+	},
+	// TODO: don't print status if all open (`--closed` could be merged/closed)
+	"status": func(pr SearchPR) string {
+		switch status := prStatus(pr); status {
+		case "Merged":
+			return fgString(status, 111, 66, 193)
+		case "Closed":
+			return fgString(status, 255, 0, 0)
+		default:
+			return fgString(status, 0, 255, 0)
+		}
+	},
+	"reviews": func(pr SearchPR) string {
+		if pr.ReviewRequests.TotalCount == 0 {
+			return ""
+		}
+		rev := "reviews"
+		if pr.ReviewRequests.TotalCount == 1 {
+			rev = "review "
+		}
+		missing := fgString(fmt.Sprintf("Missing %v %s", pr.ReviewRequests.TotalCount, rev), 255, 200, 0)
+		names := reviewers(pr)
+		for i, r := range pr.ReviewRequests.Nodes {
+			if r.State != "" {
+				names[i] += fmt.Sprintf(" (%s)", r.State)
 			}
-			reviews = fmt.Sprintf("Missing %v %s (%s)", pr.ReviewRequests.TotalCount, rev, reviewers)
 		}
+		return fmt.Sprintf("%s (%s)", missing, strings.Join(names, ", "))
+	},
+	"labels": func(pr SearchPR) string {
+		return labels(pr.Labels)
+	},
+	"updated": func(pr SearchPR) string {
+		return since(pr.UpdatedAt)
+	},
+	"created": func(pr SearchPR) string {
+		return since(pr.CreatedAt)
+	},
+}
 
-		// TODO: fix string len when using colors (breaks column width)
-		status := "Open" // rgbterm.FgString("Open", 0, 255, 0)
-		if pr.Closed {
-			status = "Closed" // rgbterm.FgString("Closed", 255, 0, 0)
-		}
-		if pr.Merged {
-			status = "Merged" // rgbterm.FgString("Merged", 111, 66, 193)
+// tableColumns returns the columns of the table, conf.Columns or the default
+// ones (with the repo of the PRs when listing all of them).
+func tableColumns(conf Conf) ([]string, error) {
+	if len(conf.Columns) == 0 {
+		if conf.All {
+			return []string{"pr", "repo", "title", "branch", "author", "status", "reviews", "labels"}, nil
 		}
+		return []string{"pr", "title", "branch", "author", "status", "reviews", "labels"}, nil
+	}
 
-		switch {
-		case conf.All:
-			fmt.Fprintf(
-				w,
-				" %v\t %s\t %s\t %s\t %s\t %s\t %s\t %s\n",
-				pr.Number,
-				pr.HeadRepositoryOwner.Login+"/"+pr.HeadRepository.Name,
-				truncate(pr.Title, 30),
-				truncate(pr.HeadRefName, 30),
-				pr.Author.Login,
-				status,
-				reviews,
-				labels(pr.Labels),
-			)
-		default:
-			fmt.Fprintf(
-				w,
-				" %v\t %s\t %s\t %s\t %s\t %s\t %s\n",
-				pr.Number,
-				truncate(pr.Title, 30),
-				truncate(pr.HeadRefName, 30),
-				pr.Author.Login,
-				status,
-				reviews,
-				labels(pr.Labels),
+	for _, c := range conf.Columns {
+		if _, ok := prsColumns[c]; !ok {
+			return nil, fmt.Errorf(
+				"Unknown column `%s`, use pr, repo, title, branch, base, author, status, reviews, labels, updated or created",
+				c,
 			)
 		}
 	}
+	return conf.Columns, nil
+}
+
+// prsTable prints the PRs as a table, once all of them are fetched so the
+// columns are aligned across pages.
+type prsTable struct {
+	t       *table
+	columns []string
+	groups  int
+}
+
+func newPRsTable(conf Conf) (*prsTable, error) {
+	columns, err := tableColumns(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	return &prsTable{t: newTable(os.Stdout), columns: columns}, nil
+}
+
+func (t *prsTable) flush() error { return nil }
+
+// footer prints the table and tells how many PRs were left out of the
+// listing, if any.
+func (t *prsTable) footer(shown, count int) error {
+	if err := t.t.flush(); err != nil {
+		return err
+	}
+	if shown < count {
+		fmt.Printf("\nShowing %d of %d PRs\n", shown, count)
+	}
+	return nil
+}
+
+func (t *prsTable) header() error {
+	cells := make([]string, len(t.columns))
+	for i, c := range t.columns {
+		cells[i] = " " + strings.ToUpper(c)
+	}
+
+	t.t.add(cells...)
+	return nil
+}
+
+// group starts a group of count PRs with its own header.
+func (t *prsTable) group(name string, count int) error {
+	if err := t.t.flush(); err != nil {
+		return err
+	}
+	if t.groups > 0 {
+		fmt.Println()
+	}
+	t.groups++
+
+	fmt.Printf("%s (%d)\n", name, count)
+	return t.header()
+}
+
+func (t *prsTable) row(pr SearchPR) error {
+	cells := make([]string, len(t.columns))
+	for i, c := range t.columns {
+		cells[i] = " " + prsColumns[c](pr)
+	}
+
+	t.t.add(cells...)
+	return nil
+}
+
+// prStatus returns whether pr is Open, Closed or Merged.
+func prStatus(pr SearchPR) string {
+	status := "Open"
+	if pr.Closed {
+		status = "Closed"
+	}
+	if pr.Merged {
+		status = "Merged"
+	}
+	return status
+}
+
+// reviewers returns the users (by login) and teams (by name) whose review is
+// requested in pr.
+func reviewers(pr SearchPR) []string {
+	names := []string{}
+	for _, r := range pr.ReviewRequests.Nodes {
+		reviewer := r.RequestedReviewer.Login
+		if reviewer == "" {
+			reviewer = r.RequestedReviewer.Name
+		}
+		names = append(names, reviewer)
+	}
+	return names
+}
 
-	w.Flush()
+// fgString colors s with the RGB color r, g, b only when stdout is a terminal,
+// as fatih/color does, so piped listings don't have escapes.
+func fgString(s string, r, g, b uint8) string {
+	if color.NoColor {
+		return s
+	}
+	return rgbterm.FgString(s, r, g, b)
 }
 
 func labels(ls Labels) string {
@@ -150,21 +551,36 @@ func labels(ls Labels) string {
 	for i, l := range ls.Nodes {
 		r, g, b := hex2rgb("#" + l.Color)
 
-		tags[i] = rgbterm.FgString(l.Name, r, g, b)
+		tags[i] = fgString(l.Name, r, g, b)
 	}
 
 	return strings.Join(tags, " ")
 }
 
+// truncate cuts str to num columns, ending it in `...`, without splitting
+// runes and counting wide ones (e.g. CJK or emoji) as two columns.
 func truncate(str string, num int) string {
-	bnoden := str
-	if len(str) > num {
-		if num > 3 {
-			num -= 3
+	if width(str) <= num {
+		return str
+	}
+	if num > 3 {
+		num -= 3
+	}
+
+	var b strings.Builder
+	w := 0
+	var prev rune
+	for _, r := range str {
+		if prev != zwj {
+			w += runeWidth(r)
+		}
+		if w > num {
+			break
 		}
-		bnoden = str[0:num] + "..."
+		b.WriteRune(r)
+		prev = r
 	}
-	return bnoden
+	return b.String() + "..."
 }
 
 func hex2rgb(hex string) (uint8, uint8, uint8) {
@@ -34,6 +34,7 @@ func main() {
 		"$ castor prs",
 		"$ castor review 14",
 		"$ castor back",
+		"$ castor status --api",
 		"$ castor config --token [token] --user [user]",
 	}, "\n   ")
 
@@ -53,6 +54,25 @@ var commands = []cli.Command{
 			"$ castor prs --closed --open=false",
 			"$ castor prs --everyone",
 			"$ castor prs --all",
+			"$ castor prs --all --everyone --limit 500",
+			"$ castor prs --max-age 10m",
+			"$ castor prs --offline",
+			"$ castor prs --all --sort updated --group-by repo",
+			"$ castor prs --columns pr,title,author,created --sort created",
+			"$ castor prs --output json\n",
+			"The table columns are pr, repo, title, branch, base, author, status, reviews,",
+			"labels, updated and created. Sorting by dates or number lists the newest first.",
+			"Tables, and sorted or grouped PRs, are printed once all of them are fetched,",
+			"counting the PRs fetched meanwhile.\n",
+			"The last listing of each search is cached. GitHub and GitLab listings are only",
+			"fetched again when a PR changed, the other hosts always fetch them.\n",
+			"`--output json`, `ndjson` and `csv` print the PRs for scripts, the fields are",
+			"documented in the readme.\n",
+			"`--format` prints each PR with a Go template using those fields (e.g. `.Title`)",
+			"and the funcs truncate, color, hex, ago, join, reviewers and labels. Save",
+			"templates with `castor config --template [name] --format [template]`:\n",
+			"$ castor prs --format '{{.Number}} {{.Title | truncate 40}} ({{ago .UpdatedAt}})'",
+			"$ castor prs --format mine",
 		}, "\n   "),
 		Aliases: []string{"ls"},
 		Action:  func(ctx *cli.Context) error { return castor.List(loadConf(ctx)) },
@@ -63,13 +83,28 @@ var commands = []cli.Command{
 		Usage: "Checkout to a PR's branch to review it",
 		UsageText: strings.Join([]string{
 			"No need to save changes (i.e. stash or commit), castor takes care of that.\n",
+			"The PR is fetched into the `castor/pr-[number]` branch, which tracks the PR so",
+			"`git pull` updates it (also for PRs from forks). Reviewing the PR again resets the",
+			"branch to the latest changes, run `castor clean` to delete these branches.\n",
+			"Initialized submodules are updated to the commits of the PR, the Work In Progress in them is",
+			"saved too and `castor back` recovers it.\n",
+			"In repos using Git LFS only the LFS files changed by the PR are fetched, the others",
+			"are left as pointer files. Use `--lfs all` to fetch all of them or `--lfs off` to",
+			"fetch none, or save it with `castor config --lfs` (`git config castor.lfs` per repo).\n",
 			"IMPORTANT: castor uses `git stash` to save the Work In Progress, if you run",
 			"`git stash drop` on it castor will not be able to go back to the branch",
 			"and, most importatly, your Work In Progress will be lost.\n",
 			"This command requires a GitHub API Token to work.",
 			"Check `castor help config` for more information.\n",
+			"Use `--worktree` to review the PR in its own git worktree instead, leaving the",
+			"current working copy untouched, and `castor back --worktree 42` to remove it.",
+			"Worktrees are created next to the repository or under `castor config --worktrees`.\n",
+			"castor refuses to switch branches during a rebase, merge, cherry-pick, revert, am",
+			"or bisect. Use `--force-worktree` to review the PR in a temporary worktree then.\n",
 			"$ castor review 42",
 			"$ castor review 42 --no-stat",
+			"$ castor review 42 --worktree",
+			"$ castor review 42 --force-worktree",
 		}, "\n   "),
 		Aliases: []string{"r"},
 		Action:  reviewAction,
@@ -82,12 +117,76 @@ var commands = []cli.Command{
 			"Goes back to the branch last brach `castor review x` was called from.",
 			"Use `--branch [branch]` to go back to a particular branch.",
 			"castor will recover the Work In Progress of the branch.\n",
+			"Reviews started from another review are nested, `castor back` goes back one",
+			"level at a time and `--all` goes back to where the first review started (keeping",
+			"the Work In Progress of the nested reviews saved, if any).",
+			"Run `castor sessions` to see the nested reviews.\n",
+			"If recovering the Work In Progress has conflicts, castor keeps it saved. Resolve",
+			"the conflicts and run `castor back --continue`, or `castor back --abort` to undo it.\n",
 			"$ castor back",
+			"$ castor back --all",
+			"$ castor back --continue",
 			"$ castor back --branch my-wip-branch",
+			"$ castor back --worktree 42",
 		}, "\n   "),
 		Aliases: []string{"b"},
 		Flags:   backFlags,
-		Action:  func(ctx *cli.Context) error { return castor.GoBack(ctx.String("branch")) },
+		Action:  backAction,
+	},
+	{
+		Name:  "sessions",
+		Usage: "List, show and drop the Work In Progress castor saved",
+		UsageText: strings.Join([]string{
+			"Lists the Work In Progress saved by `castor review`, oldest first, so nested",
+			"reviews read as a chain. Use the PR number or the ID to show or drop one.\n",
+			"$ castor sessions",
+			"$ castor sessions show 42",
+			"$ castor sessions drop 4f1c2a9",
+		}, "\n   "),
+		Action: func(ctx *cli.Context) error { return castor.Sessions() },
+		Subcommands: []cli.Command{
+			{
+				Name:      "show",
+				Usage:     "Show the diff of a Work In Progress",
+				ArgsUsage: "[PR number or ID]",
+				Action:    showSessionAction,
+			},
+			{
+				Name:      "drop",
+				Usage:     "Drop a Work In Progress, it can't be undone",
+				ArgsUsage: "[PR number or ID]",
+				Action:    dropSessionAction,
+				Flags: []cli.Flag{
+					cli.BoolFlag{
+						Name:  "yes, y",
+						Usage: "Don't ask for confirmation",
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:  "clean",
+		Usage: "Delete the branches castor fetched PRs into",
+		UsageText: strings.Join([]string{
+			"Deletes the `castor/pr-[number]` branches created by `castor review`,",
+			"except the one currently checked out.\n",
+			"$ castor clean",
+		}, "\n   "),
+		Action: func(ctx *cli.Context) error { return castor.Clean() },
+	},
+	{
+		Name:  "status",
+		Usage: "Show the Work In Progress saved by castor or the API status",
+		UsageText: strings.Join([]string{
+			"Shows the Work In Progress castor saved in this repository.",
+			"Use `--api` to see how much of the API rate limit is left instead.\n",
+			"$ castor status",
+			"$ castor status --api",
+		}, "\n   "),
+		Aliases: []string{"s"},
+		Flags:   statusFlags,
+		Action:  statusAction,
 	},
 	{
 		Name:  "config",
@@ -103,10 +202,23 @@ var commands = []cli.Command{
 			"$ castor config --token [token]",
 			"$ castor config --user [github username]",
 			"$ castor config --token [token] --user [github username]",
+			"$ castor config --worktrees ~/reviews",
+			"$ castor config --lfs all",
+			"$ castor config --template short --format '{{.Number}} {{.Title}}'\n",
+			"For GitHub Enterprise, save a token for its host, castor uses it whenever",
+			"the remote points to that host (the API defaults to https://[host]/api/graphql).",
+			"The global token is only sent to github.com:\n",
+			"$ castor config --host github.example.com --token [token]",
+			"$ castor config --host github.example.com --api https://api.example.com/graphql\n",
+			"castor also works with GitLab merge requests, Gitea (or Forgejo) and Bitbucket Server PRs,",
+			"hosts with `gitlab`, `gitea`, `forgejo` or `bitbucket` in their name (and Bitbucket's",
+			"`/scm/` remotes) are detected, otherwise set the provider of the host",
+			"(or `git config castor.provider`):\n",
+			"$ castor config --host git.example.com --provider gitlab --token [token]",
 		}, "\n   "),
 		Aliases: []string{"c"},
 		Action:  configAction,
-		Flags:   commonFlags,
+		Flags:   configFlags,
 	},
 }
 
@@ -124,6 +236,21 @@ var remoteFlag = cli.StringFlag{
 	Usage: "Repo remote",
 }
 
+var formatFlag = cli.StringFlag{
+	Name:  "format",
+	Usage: "Print each PR with a Go template, or the name of a template saved with `castor config`",
+}
+
+var lfsFlag = cli.StringFlag{
+	Name:  "lfs",
+	Usage: "Git LFS files to fetch on review: changed (by the PR), all or off",
+}
+
+var worktreesFlag = cli.StringFlag{
+	Name:  "worktrees",
+	Usage: "Dir to create review worktrees in (default: next to the repository)",
+}
+
 var commonFlags = []cli.Flag{
 	userFlag,
 	tokenFlag,
@@ -149,6 +276,36 @@ var prsFlags = append(
 		Name:  "open",
 		Usage: "Include open PRs (defaults to true)",
 	},
+	cli.IntFlag{
+		Name:  "limit",
+		Usage: "Max amount of PRs to list (0 lists all of them)",
+	},
+	cli.BoolFlag{
+		Name:  "offline",
+		Usage: "List the PRs cached by the last run of the same search",
+	},
+	cli.DurationFlag{
+		Name:  "max-age",
+		Usage: "List cached PRs without fetching them if they are not older than this (e.g. 10m)",
+	},
+	cli.StringFlag{
+		Name:  "output, o",
+		Usage: "Output format: table, json, ndjson or csv",
+		Value: "table",
+	},
+	formatFlag,
+	cli.StringFlag{
+		Name:  "columns",
+		Usage: "Columns of the table, separated by commas (e.g. pr,title,author,updated)",
+	},
+	cli.StringFlag{
+		Name:  "sort",
+		Usage: "Sort the PRs by updated, created, number, repo or author",
+	},
+	cli.StringFlag{
+		Name:  "group-by",
+		Usage: "Group the PRs of the table by repo, status or label",
+	},
 )
 
 var reviewFlags = append(
@@ -158,6 +315,48 @@ var reviewFlags = append(
 		Name:  "no-stat",
 		Usage: "Don't show diff stats after changing branch",
 	},
+	cli.BoolFlag{
+		Name:  "worktree",
+		Usage: "Review in a separate git worktree instead of stashing",
+	},
+	cli.BoolFlag{
+		Name:  "force-worktree",
+		Usage: "Review in a temporary git worktree if a rebase, merge, etc. is in progress",
+	},
+	worktreesFlag,
+	lfsFlag,
+)
+
+var statusFlags = append(
+	commonFlags,
+	remoteFlag,
+	cli.BoolFlag{
+		Name:  "api",
+		Usage: "Show the API rate limit status",
+	},
+)
+
+var configFlags = append(
+	commonFlags,
+	cli.StringFlag{
+		Name:  "host",
+		Usage: "Save --token and --api for this host instead of github.com",
+	},
+	cli.StringFlag{
+		Name:  "api",
+		Usage: "API endpoint of --host",
+	},
+	cli.StringFlag{
+		Name:  "provider",
+		Usage: "Provider of --host (github, gitlab, gitea or bitbucket)",
+	},
+	worktreesFlag,
+	lfsFlag,
+	cli.StringFlag{
+		Name:  "template",
+		Usage: "Save --format as a template with this name",
+	},
+	formatFlag,
 )
 
 var backFlags = []cli.Flag{
@@ -165,6 +364,23 @@ var backFlags = []cli.Flag{
 		Name:  "branch",
 		Usage: "Branch to go back to",
 	},
+	cli.BoolFlag{
+		Name:  "all",
+		Usage: "Go back through all the nested reviews",
+	},
+	cli.BoolFlag{
+		Name:  "continue",
+		Usage: "Finish recovering the Work In Progress once conflicts are resolved",
+	},
+	cli.BoolFlag{
+		Name:  "abort",
+		Usage: "Undo recovering the Work In Progress that had conflicts",
+	},
+	cli.StringFlag{
+		Name:  "worktree",
+		Usage: "Remove the worktree of this PR instead",
+	},
+	worktreesFlag,
 }
 
 func reviewAction(ctx *cli.Context) error {
@@ -178,6 +394,47 @@ func reviewAction(ctx *cli.Context) error {
 	return castor.ReviewPR(ctx.Args().First(), loadConf(ctx))
 }
 
+func backAction(ctx *cli.Context) error {
+	if pr := ctx.String("worktree"); pr != "" {
+		return castor.RemoveWorktree(pr, loadConf(ctx))
+	}
+	if ctx.Bool("continue") {
+		return castor.ContinueBack()
+	}
+	if ctx.Bool("abort") {
+		return castor.AbortBack()
+	}
+	if ctx.Bool("all") {
+		return castor.GoBackAll()
+	}
+
+	return castor.GoBack(ctx.String("branch"))
+}
+
+func showSessionAction(ctx *cli.Context) error {
+	if !ctx.Args().Present() {
+		return castor.ExitErrorF(1, "Missing PR number or ID")
+	}
+
+	return castor.ShowSession(ctx.Args().First())
+}
+
+func dropSessionAction(ctx *cli.Context) error {
+	if !ctx.Args().Present() {
+		return castor.ExitErrorF(1, "Missing PR number or ID")
+	}
+
+	return castor.DropSession(ctx.Args().First(), ctx.Bool("yes"))
+}
+
+func statusAction(ctx *cli.Context) error {
+	if ctx.Bool("api") {
+		return castor.APIStatus(loadConf(ctx))
+	}
+
+	return castor.Status()
+}
+
 func configAction(cxt *cli.Context) error {
 	b, err := ioutil.ReadFile(castorfile)
 	if err != nil && !os.IsNotExist(err) {
@@ -192,7 +449,14 @@ func configAction(cxt *cli.Context) error {
 		}
 	}
 
-	lookUpFlags(&conf, cxt)
+	if host := cxt.String("host"); host != "" {
+		lookUpHostFlags(&conf, host, cxt)
+	} else {
+		lookUpFlags(&conf, cxt)
+	}
+	if cxt.String("lfs") != "" {
+		conf.LFS = cxt.String("lfs")
+	}
 
 	b, err = json.Marshal(conf)
 	if err != nil {
@@ -217,9 +481,13 @@ func loadConf(ctx *cli.Context) castor.Conf {
 	}
 
 	conf := castor.Conf{
-		Token: c.Get("token").String(""),
-		User:  c.Get("user").String(""),
+		Token:     c.Get("token").String(""),
+		User:      c.Get("user").String(""),
+		Worktrees: c.Get("worktrees").String(""),
+		LFS:       c.Get("lfs").String(""),
 	}
+	c.Get("hosts").Scan(&conf.Hosts)
+	c.Get("templates").Scan(&conf.Templates)
 	lookUpFlags(&conf, ctx)
 	flagsFallbacks(&conf)
 
@@ -233,6 +501,18 @@ func lookUpFlags(conf *castor.Conf, ctx *cli.Context) {
 	if ctx.String("user") != "" {
 		conf.User = ctx.String("user")
 	}
+	if ctx.String("worktrees") != "" {
+		conf.Worktrees = ctx.String("worktrees")
+	}
+	if name := ctx.String("template"); name != "" {
+		if conf.Templates == nil {
+			conf.Templates = map[string]string{}
+		}
+		conf.Templates[name] = ctx.String("format")
+		if ctx.String("format") == "" {
+			delete(conf.Templates, name)
+		}
+	}
 
 	conf.Remote = ctx.String("remote")
 
@@ -241,6 +521,41 @@ func lookUpFlags(conf *castor.Conf, ctx *cli.Context) {
 	conf.Closed = ctx.Bool("closed")
 	conf.Open = ctx.Bool("open")
 	conf.ShowStats = !ctx.Bool("no-stat")
+	conf.Limit = ctx.Int("limit")
+	conf.Offline = ctx.Bool("offline")
+	conf.MaxAge = ctx.Duration("max-age")
+	conf.Output = ctx.String("output")
+	conf.Format = ctx.String("format")
+	conf.Sort = ctx.String("sort")
+	conf.GroupBy = ctx.String("group-by")
+	if columns := ctx.String("columns"); columns != "" {
+		conf.Columns = strings.Split(columns, ",")
+	}
+	conf.LFSFlag = ctx.String("lfs")
+	conf.Worktree = ctx.Bool("worktree")
+	conf.ForceWorktree = ctx.Bool("force-worktree")
+}
+
+func lookUpHostFlags(conf *castor.Conf, host string, ctx *cli.Context) {
+	if conf.Hosts == nil {
+		conf.Hosts = map[string]castor.HostConf{}
+	}
+
+	h := conf.Hosts[host]
+	if ctx.String("token") != "" {
+		h.Token = ctx.String("token")
+	}
+	if ctx.String("api") != "" {
+		h.API = ctx.String("api")
+	}
+	if ctx.String("provider") != "" {
+		h.Provider = ctx.String("provider")
+	}
+	conf.Hosts[host] = h
+
+	if ctx.String("user") != "" {
+		conf.User = ctx.String("user")
+	}
 }
 
 func flagsFallbacks(conf *castor.Conf) {
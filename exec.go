@@ -10,26 +10,67 @@ import (
 
 var yellow = color.New(color.FgYellow)
 
-func runWithPipe(command string, args ...string) error {
-	cmd := exec.Command(command, args...)
+// GitRunner runs the git commands castor needs.
+type GitRunner interface {
+	// Run runs a git command.
+	Run(args ...string) error
+	// RunWithPipe runs a git command, printing it and its output.
+	RunWithPipe(args ...string) error
+	// Output runs a git command and returns its trimmed output.
+	Output(args ...string) (string, error)
+	// Status returns the status of the working tree.
+	Status() (RepoStatus, error)
+}
+
+// git is the GitRunner used by castor, it's a variable to allow swapping
+// in other implementations.
+var git GitRunner = execGit{}
+
+// execGit implements GitRunner by running the git executable.
+type execGit struct{}
+
+// command returns a git command whose output doesn't depend on the locale
+// of the user, so it can be parsed.
+func (execGit) command(args ...string) *exec.Cmd {
+	cmd := exec.Command("git", args...)
+	cmd.Env = append(os.Environ(), "LC_ALL=C")
+
+	return cmd
+}
+
+func (g execGit) Run(args ...string) error {
+	return g.command(args...).Run()
+}
+
+// RunWithPipe keeps the locale of the user since its output is for them.
+func (execGit) RunWithPipe(args ...string) error {
+	cmd := exec.Command("git", args...)
 
 	cmd.Stderr = os.Stderr
 	cmd.Stdout = os.Stdout
 
-	yellow.Printf("$ %s %s\n\n", command, strings.Join(args, " "))
+	yellow.Printf("$ git %s\n\n", strings.Join(args, " "))
 
 	return cmd.Run()
 }
 
-func run(command string, args ...string) error {
-	return exec.Command(command, args...).Run()
-}
-
-func output(command string, args ...string) (string, error) {
-	out, err := exec.Command(command, args...).Output()
+func (g execGit) Output(args ...string) (string, error) {
+	out, err := g.command(args...).Output()
 	if err != nil {
 		return "", err
 	}
 
 	return strings.TrimSpace(string(out)), nil
 }
+
+func (g execGit) Status() (RepoStatus, error) {
+	out, err := g.command("status", "--porcelain=v2", "--branch", "-z", "--untracked-files=all").Output()
+	if err != nil {
+		return RepoStatus{}, err
+	}
+
+	status := parseStatus(string(out))
+	detectState(g, &status)
+
+	return status, nil
+}
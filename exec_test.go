@@ -0,0 +1,109 @@
+package castor
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// fakeGit is a GitRunner returning canned outputs, the commands without one
+// fail.
+type fakeGit struct {
+	outputs map[string]string
+	// ran records the commands run, if not nil.
+	ran *[]string
+}
+
+func (g fakeGit) Run(args ...string) error {
+	_, err := g.Output(args...)
+	return err
+}
+
+func (g fakeGit) RunWithPipe(args ...string) error {
+	return g.Run(args...)
+}
+
+func (g fakeGit) Output(args ...string) (string, error) {
+	cmd := strings.Join(args, " ")
+	if g.ran != nil {
+		*g.ran = append(*g.ran, cmd)
+	}
+
+	out, ok := g.outputs[cmd]
+	if !ok {
+		return "", errors.New("exit status 1")
+	}
+	return out, nil
+}
+
+func (g fakeGit) Status() (RepoStatus, error) {
+	out, err := g.Output("status")
+	if err != nil {
+		return RepoStatus{}, err
+	}
+
+	status := parseStatus(out)
+	detectState(g, &status)
+	return status, nil
+}
+
+// withGit swaps the GitRunner castor uses for g during a test.
+func withGit(t *testing.T, g GitRunner) {
+	prev := git
+	git = g
+	t.Cleanup(func() { git = prev })
+}
+
+func TestGitRunnerSwap(t *testing.T) {
+	tests := []struct {
+		name    string
+		outputs map[string]string
+		want    string
+		ran     []string
+	}{
+		{
+			name:    "branch",
+			outputs: map[string]string{"symbolic-ref --quiet --short HEAD": "feature"},
+			want:    "feature",
+			ran:     []string{"symbolic-ref --quiet --short HEAD"},
+		},
+		{
+			name:    "detached",
+			outputs: map[string]string{"rev-parse HEAD": "4a3a32b7bda8646c0b23c0af92816080e94bb637"},
+			want:    "4a3a32b7bda8646c0b23c0af92816080e94bb637",
+			ran:     []string{"symbolic-ref --quiet --short HEAD", "rev-parse HEAD"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ran []string
+			withGit(t, fakeGit{outputs: tt.outputs, ran: &ran})
+
+			got, err := currentBranch()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got branch %s, want %s", got, tt.want)
+			}
+			if strings.Join(ran, "\n") != strings.Join(tt.ran, "\n") {
+				t.Errorf("ran %q, want %q", ran, tt.ran)
+			}
+		})
+	}
+
+	t.Run("status", func(t *testing.T) {
+		withGit(t, fakeGit{outputs: map[string]string{
+			"status": "# branch.oid 4a3a32b7bda8646c0b23c0af92816080e94bb637\x00# branch.head main\x00? new.txt\x00",
+		}})
+
+		status, err := git.Status()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.Branch != "main" || status.Clean() {
+			t.Errorf("got %+v, want main with an untracked file", status)
+		}
+	})
+}
@@ -3,68 +3,230 @@ package castor
 import (
 	"fmt"
 	"os"
-	"regexp"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/whilp/git-urls"
 )
 
 var castorWIPMsg = "[CASTOR WIP]"
+
+// castorWIPFile was created by older castor versions to stash clean trees.
 var castorWIPFile = ".castorwip"
 
-func checkoutBranch(branch string) error {
+// prBranch returns the local branch castor fetches the head of PR n into.
+func prBranch(n int) string {
+	return fmt.Sprintf("castor/pr-%d", n)
+}
+
+// checkoutPR fetches ref, the head of a PR in the remote, into branch and
+// checks it out. The ref is set as the upstream of the branch so `git pull`
+// keeps it up to date, which also works for PRs from forks. The checkout
+// is run with the git options in opts (e.g. to skip fetching LFS objects).
+func checkoutPR(remote, ref, branch string, opts []string) error {
+	cur, err := currentBranch()
+	if err != nil {
+		return err
+	}
+
+	if cur == branch {
+		fmt.Printf("\nUpdating branch `%s`\n\n", branch)
+		return updatePR(".", remote, ref, opts)
+	}
+
+	if err := fetchPR(remote, ref, branch); err != nil {
+		return err
+	}
+
 	fmt.Printf("\nSwitching to branch `%s`\n\n", branch)
-	if err := runWithPipe("git", "checkout", branch); err != nil {
-		fmt.Println()
-		if err := runWithPipe("git", "fetch"); err != nil {
-			return err
+	return git.RunWithPipe(append(opts, "checkout", branch)...)
+}
+
+// saveWIP stashes the Work In Progress of the repo and its submodules. It
+// returns the SHA of the stash, empty when there was nothing to stash.
+func saveWIP(status RepoStatus) ([]submoduleWIP, string, error) {
+	subs, err := saveSubmodules(status)
+	if err != nil {
+		if rerr := restoreSubmodules(subs); rerr != nil {
+			fmt.Printf("\n%s\n", rerr)
 		}
-		fmt.Println()
-		if err := runWithPipe("git", "checkout", branch); err != nil {
-			return err
+		return nil, "", err
+	}
+	if len(subs) > 0 {
+		// the changes of the submodules aren't there anymore
+		if status, err = git.Status(); err != nil {
+			return subs, "", err
 		}
 	}
-	fmt.Println()
-	return nil
+
+	// a clean tree has nothing to stash, the session alone keeps the
+	// reference to the branch
+	if status.Clean() {
+		fmt.Print("Repository is clean, nothing to save\n\n")
+		return subs, "", nil
+	}
+
+	fmt.Printf("Saving Work In Progress\n\n")
+	stash, err := saveStash(".")
+	if err != nil {
+		fmt.Printf("\nCouldn't stash files...\n\n")
+		return subs, "", err
+	}
+	if stash == "" {
+		fmt.Print("\nGit found nothing to save\n\n")
+	}
+	return subs, stash, nil
 }
 
-func switchToBranch(base, head string, conf Conf) error {
+// saveStash stashes the Work In Progress of the repository in dir, including
+// untracked files, and returns the SHA of its stash commit. It's empty when
+// git found nothing to stash (e.g. the untracked files are ignored), since
+// `git stash` doesn't fail then and refs/stash is still the previous stash.
+func saveStash(dir string) (string, error) {
+	var args []string
+	if dir != "." {
+		args = []string{"-C", dir}
+	}
+
+	before, _ := git.Output(append(args, "rev-parse", "--verify", "--quiet", "refs/stash")...)
+	if err := git.RunWithPipe(append(args, "stash", "push", "-u", "-m", castorWIPMsg)...); err != nil {
+		return "", err
+	}
+
+	after, err := git.Output(append(args, "rev-parse", "--verify", "--quiet", "refs/stash")...)
+	if err != nil || after == before {
+		return "", nil
+	}
+	return after, nil
+}
+
+// updatePR resets the PR branch checked out in dir to ref, the latest head of
+// the PR, also when it was force pushed. `reset --keep` refuses to reset the
+// files with local changes instead of losing them.
+func updatePR(dir, remote, ref string, opts []string) error {
+	var args []string
+	if dir != "." {
+		args = []string{"-C", dir}
+	}
+
+	if err := git.RunWithPipe(append(args, "fetch", remote, ref)...); err != nil {
+		return err
+	}
+	return git.RunWithPipe(append(append(opts, args...), "reset", "--keep", "FETCH_HEAD")...)
+}
+
+// fetchPR fetches ref into branch, setting ref as its upstream.
+func fetchPR(remote, ref, branch string) error {
+	fmt.Printf("\nFetching `%s` into branch `%s`\n\n", ref, branch)
+	if err := git.RunWithPipe("fetch", remote, "+"+ref+":refs/heads/"+branch); err != nil {
+		return err
+	}
+	if err := git.Run("config", "branch."+branch+".remote", remote); err != nil {
+		return err
+	}
+	return git.Run("config", "branch."+branch+".merge", ref)
+}
+
+func switchToBranch(n int, base, head, ref string, conf Conf) error {
 	if !isRepo() {
 		return fmt.Errorf("Not a git repository")
 	}
 
-	if isClean() {
-		fmt.Print("Repository is clean, creating .castorwip to keep a reference to the branch\n\n")
-		f, err := os.Create(castorWIPFile)
-		if err != nil {
-			return err
-		}
-		f.Close()
+	status, err := git.Status()
+	if err != nil {
+		return err
+	}
+	if err := checkSafe(status); err != nil {
+		return err
+	}
+	if path, ok, err := checkedOutElsewhere(prBranch(n)); err != nil {
+		return err
+	} else if ok {
+		return fmt.Errorf(
+			"PR #%d is checked out in the worktree %s, review it there or remove it with `castor back --worktree %d`",
+			n,
+			path,
+			n,
+		)
+	}
+	if status.Sparse {
+		fmt.Print("Warning: this is a sparse checkout, only the files in it will be checked out\n\n")
 	}
 
-	fmt.Printf("Saving Work In Progress\n\n")
-	if err := runWithPipe("git", "stash", "save", "-u", castorWIPMsg); err != nil {
-		fmt.Printf("\nCouldn't stash files...\n\n")
+	lfs, err := lfsMode(conf)
+	if err != nil {
 		return err
 	}
 
-	if err := checkoutBranch(head); err != nil {
-		fmt.Printf("\nFailed to checkout to branch `%s`, applying Work In Progress back\n\n", head)
-		if err := runWithPipe("git", "stash", "pop"); err != nil {
-			fmt.Printf("\nFailed to apply changes...\n\n")
+	cur := status.current()
+	if status.Detached {
+		fmt.Printf("HEAD is detached at %.7s, castor will go back to that commit\n\n", cur)
+	}
+
+	// reviewing the PR again updates its branch in place, `reset --keep`
+	// keeps the local changes so there's nothing to save nor to go back to
+	branch := prBranch(n)
+	updating := cur == branch
+
+	var subs []submoduleWIP
+	var stash string
+	if !updating {
+		if subs, stash, err = saveWIP(status); err != nil {
 			return err
 		}
+	}
+	clean := stash == ""
+
+	if err := checkoutPR(conf.Remote, ref, branch, lfsCheckoutArgs(lfs)); err != nil {
+		if !clean {
+			// the stash is applied by its SHA, as restoreSession does, and
+			// with its index so the staged changes stay staged
+			fmt.Printf("\nFailed to checkout PR #%d, applying Work In Progress back\n\n", n)
+			if err := git.RunWithPipe("stash", "apply", "--index", stash); err != nil {
+				fmt.Printf("\nFailed to apply changes, they are still saved in the stash as %s\n\n", stash)
+				return err
+			}
+			if ref, ok := stashRef(stash); ok {
+				if err := git.Run("stash", "drop", ref); err != nil {
+					return err
+				}
+			}
+		}
+		if err := restoreSubmodules(subs); err != nil {
+			fmt.Printf("\n%s\n", err)
+		}
 		return err
 	}
+	if err := updateSubmodules(); err != nil {
+		fmt.Printf("\nCouldn't update the submodules: %s\n", err)
+	}
+	if err := pullLFS(".", conf.Remote, base, branch, lfs); err != nil {
+		fmt.Printf("\nCouldn't fetch the Git LFS files: %s\n", err)
+	}
+
+	if !updating {
+		err = addSession(session{
+			Branch:     cur,
+			Stash:      stash,
+			PR:         n,
+			StartedAt:  time.Now(),
+			Clean:      clean,
+			Submodules: subs,
+		})
+		if err != nil {
+			fmt.Printf("\nCouldn't save the review session: %s\n", err)
+		}
+	}
 
-	if err := runWithPipe("git", "pull", conf.Remote, head); err != nil {
-		fmt.Printf("\nSwitched to `%s` but failed to pull latest changes...\n", head)
+	if updating {
+		fmt.Printf("\nUpdated branch `%s` (PR #%d from `%s`)\n", branch, n, head)
 	} else {
-		fmt.Printf("\nSwitched to branch `%s`\n", head)
+		fmt.Printf("\nSwitched to branch `%s` (PR #%d from `%s`)\n", branch, n, head)
 	}
 
 	if conf.ShowStats {
-		diff, err := statDiff(base, head)
+		diff, err := statDiff(base, branch)
 		if err != nil {
 			return err
 		}
@@ -74,21 +236,86 @@ func switchToBranch(base, head string, conf Conf) error {
 	return nil
 }
 
+// cleanPRBranches deletes the branches castor fetched PRs into, except the
+// ones checked out in a worktree (including the current one).
+func cleanPRBranches() error {
+	if !isRepo() {
+		return fmt.Errorf("Not a git repository")
+	}
+
+	branches, err := checkedOut()
+	if err != nil {
+		return err
+	}
+	sessions, err := loadSessions()
+	if err != nil {
+		return err
+	}
+	// the branches sessions go back to, e.g. a PR left for another review
+	saved := map[string]bool{}
+	for _, s := range sessions {
+		saved[s.Branch] = true
+	}
+
+	out, err := git.Output("for-each-ref", "--format=%(refname:short)", "refs/heads/castor/")
+	if err != nil {
+		return err
+	}
+
+	var failed []string
+	for _, branch := range strings.Fields(out) {
+		if path, ok := branches[branch]; ok {
+			fmt.Printf("Keeping branch `%s`, it's checked out in %s\n", branch, path)
+			continue
+		}
+		if saved[branch] {
+			fmt.Printf("Keeping branch `%s`, castor saved Work In Progress to go back to it\n", branch)
+			continue
+		}
+		if err := git.Run("branch", "-D", branch); err != nil {
+			fmt.Printf("Couldn't delete branch `%s`\n", branch)
+			failed = append(failed, branch)
+			continue
+		}
+		fmt.Printf("Deleted branch `%s`\n", branch)
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("Couldn't delete %d branches", len(failed))
+	}
+	return nil
+}
+
 func goBack(branch string) error {
 	if !isRepo() {
 		return fmt.Errorf("Not a git repository")
 	}
 
-	cur, err := currentBranch()
+	if _, ok, _ := loadRestore(); ok {
+		return fmt.Errorf("Recovering a Work In Progress is in progress, run `castor back --continue` or `castor back --abort`")
+	}
+
+	status, err := git.Status()
 	if err != nil {
 		return err
 	}
+	if err := checkSafe(status); err != nil {
+		return err
+	}
+
+	cur := status.current()
 
 	if branch == cur {
 		return fmt.Errorf("Already in branch `%s`", branch)
 	}
 
-	wip, ok := stashWIP(branch)
+	wip, ok, err := findSession(branch)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		wip, ok = legacySession(branch)
+	}
 	if !ok {
 		if branch == "" {
 			return fmt.Errorf("Castor didn't save any Work In Progress in this repository")
@@ -96,81 +323,318 @@ func goBack(branch string) error {
 		return fmt.Errorf("Castor didn't save any Work In Progress in branch `%s`", branch)
 	}
 
-	if cur != wip.branch {
-		fmt.Printf("Checkingout back to branch `%s`\n\n", wip.branch)
+	return restoreSession(cur, wip)
+}
+
+// restoreSession checks out the branch of wip and recovers its Work In Progress.
+func restoreSession(cur string, wip session) error {
+	if wip.Clean {
+		if cur != wip.Branch {
+			fmt.Printf("Checkingout back to branch `%s`\n\n", wip.Branch)
+
+			if err := git.RunWithPipe("checkout", wip.Branch); err != nil {
+				return err
+			}
+			if err := updateSubmodules(); err != nil {
+				return err
+			}
+		}
+		if err := restoreSubmodules(wip.Submodules); err != nil {
+			return err
+		}
+		return removeSession(wip)
+	}
+
+	ref, ok := stashRef(wip.Stash)
+	if !ok {
+		if err := removeSession(wip); err != nil {
+			return err
+		}
+		return fmt.Errorf(
+			"The Work In Progress of branch `%s` is no longer in the stash, try recovering it with `git stash apply %s`",
+			wip.Branch,
+			wip.Stash,
+		)
+	}
+
+	if cur != wip.Branch {
+		fmt.Printf("Checkingout back to branch `%s`\n\n", wip.Branch)
 
-		err = runWithPipe("git", "checkout", wip.branch)
+		err := git.RunWithPipe("checkout", wip.Branch)
 		if err != nil {
 			return err
 		}
+		if err := updateSubmodules(); err != nil {
+			return err
+		}
 	}
 
 	fmt.Printf("Recovering your Work In Progress\n\n")
 
-	err = runWithPipe("git", "stash", "pop", wip.id)
+	// apply instead of pop so the stash is only dropped once recovered
+	index := true
+	err := git.RunWithPipe("stash", "apply", "--index", ref)
+	if err != nil && len(conflicts()) == 0 {
+		fmt.Printf("\nCouldn't recover the staged changes, recovering them as unstaged\n\n")
+		index = false
+		err = git.RunWithPipe("stash", "apply", ref)
+	}
+
+	if files := conflicts(); len(files) > 0 {
+		if err := saveRestore(wip); err != nil {
+			return err
+		}
+		return fmt.Errorf(
+			"Couldn't recover your Work In Progress, these files have conflicts:\n\n  %s\n\n"+
+				"Your Work In Progress is still saved. Resolve the conflicts and `git add` the files,\n"+
+				"then run `castor back --continue`, or run `castor back --abort` to undo the recovery",
+			strings.Join(files, "\n  "),
+		)
+	}
 	if err != nil {
 		return err
 	}
 
-	if _, err := os.Stat(castorWIPFile); !os.IsNotExist(err) {
-		err := os.Remove(castorWIPFile)
-		if err != nil {
+	if err := restoreSubmodules(wip.Submodules); err != nil {
+		return err
+	}
+
+	if index {
+		if err := verifyRestore(wip.Stash); err != nil {
+			fmt.Printf("\n%s\n", err)
+		}
+	}
+
+	return finishRestore(wip)
+}
+
+// finishRestore drops the stash and the session of a recovered Work In Progress.
+func finishRestore(wip session) error {
+	if ref, ok := stashRef(wip.Stash); ok {
+		if err := git.Run("stash", "drop", ref); err != nil {
+			return err
+		}
+	}
+
+	if err := removeSession(wip); err != nil {
+		return err
+	}
+	if err := clearRestore(); err != nil {
+		return err
+	}
+
+	if !wip.WIPFile {
+		return nil
+	}
+	top, err := git.Output("rev-parse", "--show-toplevel")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(top, castorWIPFile)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		if err := os.Remove(path); err != nil {
 			return err
 		}
 		fmt.Print("\nRemoved .castorwip file\n\n")
-		return runWithPipe("git", "status")
+		return git.RunWithPipe("status")
+	}
+
+	return nil
+}
+
+// continueRestore finishes recovering a Work In Progress that had conflicts,
+// once they are resolved.
+func continueRestore() error {
+	wip, ok, err := loadRestore()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("There's no Work In Progress being recovered")
+	}
+
+	if files := conflicts(); len(files) > 0 {
+		return fmt.Errorf(
+			"These files still have conflicts, resolve them and `git add` them:\n\n  %s",
+			strings.Join(files, "\n  "),
+		)
+	}
+
+	fmt.Printf("Recovered your Work In Progress\n\n")
+	if err := restoreSubmodules(wip.Submodules); err != nil {
+		return err
+	}
+	return finishRestore(wip)
+}
+
+// abortRestore undoes recovering a Work In Progress that had conflicts,
+// keeping it saved to try again later.
+func abortRestore() error {
+	wip, ok, err := loadRestore()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("There's no Work In Progress being recovered")
+	}
+
+	if err := git.RunWithPipe("reset", "--merge"); err != nil {
+		return err
+	}
+
+	// git refuses to apply a stash over existing untracked files, so the
+	// ones in it were created by the recovery
+	top, err := git.Output("rev-parse", "--show-toplevel")
+	if err != nil {
+		return err
+	}
+	untracked, _ := git.Output("ls-tree", "-r", "--name-only", wip.Stash+"^3")
+	for _, f := range strings.Split(untracked, "\n") {
+		if f == "" {
+			continue
+		}
+		if err := os.Remove(filepath.Join(top, f)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
 	}
 
+	if err := clearRestore(); err != nil {
+		return err
+	}
+
+	fmt.Printf("\nUndid the recovery, your Work In Progress is still saved, run `castor back` to try again\n")
 	return nil
 }
 
+// conflicts returns the files with unresolved conflicts.
+func conflicts() []string {
+	out, _ := git.Output("diff", "--name-only", "--diff-filter=U")
+	return strings.Fields(out)
+}
+
+// verifyRestore checks that the index and the working tree match the ones
+// saved in the stash commit sha, whose second parent holds the index.
+func verifyRestore(sha string) error {
+	// submodules are compared by their commit, their changes aren't in sha
+	staged, err := git.Output("diff", "--cached", "--name-only", "--ignore-submodules=dirty", sha+"^2")
+	if err != nil {
+		return err
+	}
+	unstaged, err := git.Output("diff", "--name-only", "--ignore-submodules=dirty", sha)
+	if err != nil {
+		return err
+	}
+
+	if staged == "" && unstaged == "" {
+		return nil
+	}
+
+	msg := "The recovered Work In Progress doesn't match what was saved:"
+	for _, f := range strings.Fields(staged) {
+		msg += "\n  staged:   " + f
+	}
+	for _, f := range strings.Fields(unstaged) {
+		msg += "\n  unstaged: " + f
+	}
+	return fmt.Errorf("%s", msg)
+}
+
+// legacySession finds Work In Progress saved by castor versions that didn't
+// keep sessions, by its stash message.
+func legacySession(branch string) (session, bool) {
+	wip, ok := stashWIP(branch)
+	if !ok {
+		return session{}, false
+	}
+
+	sha, err := git.Output("rev-parse", "--verify", wip.id)
+	if err != nil {
+		return session{}, false
+	}
+
+	return session{Branch: wip.branch, Stash: sha, WIPFile: stashHasWIPFile(sha)}, true
+}
+
+// currentBranch returns the current branch, or the SHA of the current commit
+// if HEAD is detached.
 func currentBranch() (string, error) {
-	return output("git", "rev-parse", "--abbrev-ref", "HEAD")
+	if branch, err := git.Output("symbolic-ref", "--quiet", "--short", "HEAD"); err == nil {
+		return branch, nil
+	}
+	return git.Output("rev-parse", "HEAD")
 }
 
 func isRepo() bool {
-	return run("git", "rev-parse") == nil
+	return git.Run("rev-parse") == nil
 }
 
-func ownerAndRepo(remote string) (string, string, error) {
+func remoteRepository(remote string) (repository, error) {
 	rawurl, err := remoteURL(remote)
 	if err != nil {
-		return "", "", err
+		return repository{}, err
 	}
 
-	return ownerAndRepoFromRemote(rawurl)
+	return parseRemote(rawurl)
 }
 
-var re = regexp.MustCompile(`[a-zA-Z-]+/[a-zA-Z-]+`)
-
-func ownerAndRepoFromRemote(remote string) (string, string, error) {
+// parseRemote parses the owner and name of the repo from a remote URL.
+//
+// Bitbucket Server remotes are detected by their `scm/` prefix over HTTP
+// (e.g. https://host/scm/PROJ/repo.git) or its default SSH port 7999, and
+// the owner is the project key (`~user` for personal repos).
+func parseRemote(remote string) (repository, error) {
 	url, err := giturls.Parse(remote)
 	if err != nil {
-		return "", "", err
+		return repository{}, err
 	}
 
-	parts := strings.Split(re.FindString(url.Path), "/")
+	repoPath := strings.Trim(url.Path, "/")
 
-	// TODO: handle len != 2 case (could be many things)
-	if len(parts) != 2 {
-		return "", "", fmt.Errorf("Cannot parse owner and repo from git remote origin")
+	var provider string
+	if strings.HasPrefix(repoPath, "scm/") || url.Port() == "7999" {
+		provider = "bitbucket"
+		repoPath = strings.TrimPrefix(repoPath, "scm/")
 	}
 
-	return parts[0], parts[1], nil
+	repoPath = strings.TrimSuffix(repoPath, ".git")
+
+	// the owner and name are the last segments of the path, GitLab groups
+	// can nest (e.g. group/subgroup/repo)
+	parts := strings.Split(repoPath, "/")
+	if len(parts) < 2 || parts[len(parts)-2] == "" || parts[len(parts)-1] == "" {
+		return repository{}, fmt.Errorf("Cannot parse owner and repo from git remote origin")
+	}
+
+	return repository{
+		host:     url.Hostname(),
+		owner:    parts[len(parts)-2],
+		name:     parts[len(parts)-1],
+		path:     repoPath,
+		provider: provider,
+	}, nil
 }
 
 func remoteURL(remote string) (string, error) {
-	return output("git", "remote", "get-url", remote)
+	return git.Output("remote", "get-url", remote)
+}
+
+// repoProvider returns `git config castor.provider` or empty string
+func repoProvider() string {
+	provider, _ := git.Output("config", "castor.provider")
+	return provider
 }
 
 func lastCommit() (string, error) {
-	return output("git", "log", "--pretty=format:%s", "-n", "1")
+	return git.Output("log", "--pretty=format:%s", "-n", "1")
 }
 
 type stashEntry struct {
 	id     string
 	branch string
 	msg    string
+	// sha is the SHA of the stash commit.
+	sha     string
+	savedAt time.Time
 }
 
 // stashWIP finds the castor WIP branch by parcing the git stash output:
@@ -182,7 +646,7 @@ type stashEntry struct {
 //
 // If branch is an empty string, returns the last WIP branch.
 func stashWIP(branch string) (stashEntry, bool) {
-	stash, err := output("git", "stash", "list")
+	stash, err := git.Output("stash", "list")
 	if err != nil {
 		return stashEntry{}, false
 	}
@@ -204,18 +668,12 @@ func stashWIP(branch string) (stashEntry, bool) {
 	return stashEntry{}, false
 }
 
-func isClean() bool {
-	out, _ := output("git", "status")
-
-	return strings.Index(out, "nothing to commit") != -1
-}
-
 func statDiff(base, head string) (string, error) {
-	return output("git", "diff", "--stat", "--color", base+".."+head)
+	return git.Output("diff", "--stat", "--color", base+".."+head)
 }
 
 func gitUser() (string, error) {
-	return output("git", "config", "--global", "user.name")
+	return git.Output("config", "--global", "user.name")
 }
 
 // GitUser returns `git config --global user.name` or empty string
@@ -225,7 +683,7 @@ func GitUser() string {
 }
 
 func gitRemote() (string, error) {
-	return output("git", "remote")
+	return git.Output("remote")
 }
 
 // GitRemote returns `git remote` or empty string
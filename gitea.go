@@ -0,0 +1,297 @@
+package castor
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// gitea implements Provider using the REST API (v1) of Gitea, which Forgejo
+// also serves.
+type gitea struct {
+	api   string
+	repo  repository
+	token string
+}
+
+func newGitea(repo repository, conf Conf) Provider {
+	host := conf.host(repo.host)
+	api := host.API
+	if api == "" {
+		api = "https://" + repo.host + "/api/v1"
+	}
+
+	return &gitea{api: strings.TrimSuffix(api, "/"), repo: repo, token: host.Token}
+}
+
+type giteaUser struct {
+	Login string `json:"login"`
+}
+
+type giteaPR struct {
+	Number             int         `json:"number"`
+	Title              string      `json:"title"`
+	HTMLURL            string      `json:"html_url"`
+	State              string      `json:"state"`
+	Merged             bool        `json:"merged"`
+	User               giteaUser   `json:"user"`
+	Labels             []Label     `json:"labels"`
+	Assignees          []giteaUser `json:"assignees"`
+	RequestedReviewers []giteaUser `json:"requested_reviewers"`
+	Head               giteaRef    `json:"head"`
+	Base               giteaRef    `json:"base"`
+	CreatedAt          time.Time   `json:"created_at"`
+	UpdatedAt          time.Time   `json:"updated_at"`
+}
+
+type giteaRef struct {
+	Ref  string `json:"ref"`
+	Repo struct {
+		Name  string    `json:"name"`
+		Owner giteaUser `json:"owner"`
+	} `json:"repo"`
+}
+
+type giteaIssue struct {
+	Number     int `json:"number"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+}
+
+// giteaPageSize is the amount of results requested per page, Gitea caps it
+// with its MAX_RESPONSE_ITEMS setting (50 by default).
+const giteaPageSize = 50
+
+func (g *gitea) SearchPRs(conf Conf, page func(PRsSearch) error) error {
+	if conf.All {
+		return g.searchAll(conf, page)
+	}
+	return g.searchRepo(conf, page)
+}
+
+// searchRepo lists the PRs of the repo a page at a time, filtering the ones
+// the user is involved in locally since the pulls endpoint can't. The filter
+// reads all the pages to count the PRs, even past conf.Limit.
+func (g *gitea) searchRepo(conf Conf, page func(PRsSearch) error) error {
+	q := url.Values{"state": {giteaState(conf)}}
+	endpoint := fmt.Sprintf("/repos/%s/%s/pulls", g.repo.owner, g.repo.name)
+
+	shown, count := 0, 0
+	for n := 1; ; n++ {
+		var prs []giteaPR
+		next, total, err := g.getPage(endpoint, q, n, &prs)
+		if err != nil {
+			return err
+		}
+
+		search := PRsSearch{}
+		for _, pr := range prs {
+			if !conf.Everyone && !pr.involves(conf.User) {
+				continue
+			}
+			count++
+			if conf.Limit > 0 && shown >= conf.Limit {
+				continue
+			}
+			search.Nodes = append(search.Nodes, pr.toPR())
+			shown++
+		}
+		search.IssueCount = count
+		counted := conf.Everyone && total >= 0
+		if counted {
+			search.IssueCount = total
+		}
+
+		if err := page(search); err != nil {
+			return err
+		}
+		if !next || (counted && conf.Limit > 0 && shown >= conf.Limit) {
+			return nil
+		}
+	}
+}
+
+// searchAll lists the PRs across repos using the issues search, which is
+// scoped to the owner of the token, and then fetches each PR up to
+// conf.Limit. The issues the user is involved in are searched with a query
+// per filter, so all their pages are read to count the PRs once.
+func (g *gitea) searchAll(conf Conf, page func(PRsSearch) error) error {
+	filters := []url.Values{{}}
+	if !conf.Everyone {
+		filters = []url.Values{
+			{"created": {"true"}},
+			{"review_requested": {"true"}},
+			{"assigned": {"true"}},
+		}
+	}
+
+	var issues []giteaIssue
+	seen := map[string]bool{}
+	count := -1
+	for _, q := range filters {
+		q.Set("type", "pulls")
+		q.Set("state", giteaState(conf))
+
+		for n := 1; ; n++ {
+			var res []giteaIssue
+			next, total, err := g.getPage("/repos/issues/search", q, n, &res)
+			if err != nil {
+				return err
+			}
+
+			for _, issue := range res {
+				id := fmt.Sprintf("%s#%d", issue.Repository.FullName, issue.Number)
+				if !seen[id] {
+					seen[id] = true
+					issues = append(issues, issue)
+				}
+			}
+
+			if len(filters) == 1 {
+				count = total
+			}
+			if !next || (count >= 0 && conf.Limit > 0 && len(issues) >= conf.Limit) {
+				break
+			}
+		}
+	}
+
+	search := PRsSearch{IssueCount: count}
+	if count < 0 {
+		search.IssueCount = len(issues)
+	}
+	for _, issue := range issues {
+		if conf.Limit > 0 && len(search.Nodes) >= conf.Limit {
+			break
+		}
+
+		var pr giteaPR
+		endpoint := fmt.Sprintf("/repos/%s/pulls/%d", issue.Repository.FullName, issue.Number)
+		if err := g.get(endpoint, &pr); err != nil {
+			return err
+		}
+		search.Nodes = append(search.Nodes, pr.toPR())
+	}
+
+	return page(search)
+}
+
+func giteaState(conf Conf) string {
+	switch {
+	case conf.Open && !conf.Closed:
+		return "open"
+	case conf.Closed && !conf.Open:
+		return "closed"
+	default:
+		return "all"
+	}
+}
+
+func (g *gitea) PRRefs(n int) (string, string, error) {
+	pr, err := g.pr(n)
+	if err != nil {
+		return "", "", err
+	}
+
+	return pr.Base.Ref, pr.Head.Ref, nil
+}
+
+func (g *gitea) PR(n int) (SearchPR, error) {
+	pr, err := g.pr(n)
+	if err != nil {
+		return SearchPR{}, err
+	}
+
+	return pr.toPR(), nil
+}
+
+func (g *gitea) HeadRef(n int) string {
+	return fmt.Sprintf("refs/pull/%d/head", n)
+}
+
+func (g *gitea) pr(n int) (giteaPR, error) {
+	var pr giteaPR
+	err := g.get(fmt.Sprintf("/repos/%s/%s/pulls/%d", g.repo.owner, g.repo.name, n), &pr)
+	return pr, err
+}
+
+func (pr giteaPR) involves(user string) bool {
+	if pr.User.Login == user {
+		return true
+	}
+	for _, u := range pr.Assignees {
+		if u.Login == user {
+			return true
+		}
+	}
+	for _, u := range pr.RequestedReviewers {
+		if u.Login == user {
+			return true
+		}
+	}
+	return false
+}
+
+func (pr giteaPR) toPR() SearchPR {
+	p := SearchPR{
+		URL:         pr.HTMLURL,
+		Number:      pr.Number,
+		Title:       pr.Title,
+		Author:      WithLogin{pr.User.Login},
+		HeadRefName: pr.Head.Ref,
+		BaseRefName: pr.Base.Ref,
+		Closed:      pr.State == "closed",
+		Merged:      pr.Merged,
+		Labels:      Labels{TotalCount: len(pr.Labels), Nodes: pr.Labels},
+		CreatedAt:   pr.CreatedAt,
+		UpdatedAt:   pr.UpdatedAt,
+	}
+	p.HeadRepository.Name = pr.Head.Repo.Name
+	p.HeadRepositoryOwner.Login = pr.Head.Repo.Owner.Login
+
+	for _, r := range pr.RequestedReviewers {
+		p.ReviewRequests.Nodes = append(p.ReviewRequests.Nodes, RequestedReviewer{RequestedReviewer: LoginAndName{Login: r.Login}})
+	}
+	p.ReviewRequests.TotalCount = len(p.ReviewRequests.Nodes)
+
+	return p
+}
+
+func (g *gitea) get(endpoint string, v interface{}) error {
+	return getJSON(g.api+endpoint, g.header(), v)
+}
+
+// getPage gets page n of endpoint with the query q into v. It returns if
+// there are more pages and the total of results, -1 when Gitea doesn't send
+// it.
+func (g *gitea) getPage(endpoint string, q url.Values, n int, v interface{}) (bool, int, error) {
+	q.Set("limit", strconv.Itoa(giteaPageSize))
+	q.Set("page", strconv.Itoa(n))
+
+	header, err := getJSONHeader(g.api+endpoint+"?"+q.Encode(), g.header(), v)
+	if err != nil {
+		return false, 0, err
+	}
+
+	total, err := strconv.Atoi(header.Get("X-Total-Count"))
+	if err != nil {
+		total = -1
+	}
+
+	if link := header.Get("Link"); link != "" {
+		return strings.Contains(link, `rel="next"`), total, nil
+	}
+	return total >= 0 && n*giteaPageSize < total, total, nil
+}
+
+func (g *gitea) header() http.Header {
+	header := http.Header{}
+	if g.token != "" {
+		header.Set("Authorization", "token "+g.token)
+	}
+	return header
+}
@@ -0,0 +1,152 @@
+package castor
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// giteaServer serves n PRs of org/repo, paginated as Gitea does, every third
+// of them authored by `me`.
+func giteaServer(t *testing.T, n int) *httptest.Server {
+	prs := make([]giteaPR, n)
+	for i := range prs {
+		prs[i].Number = i + 1
+		prs[i].Title = fmt.Sprintf("PR %d", i+1)
+		prs[i].User.Login = "someone"
+		if i%3 == 0 {
+			prs[i].User.Login = "me"
+		}
+		prs[i].Head.Ref = fmt.Sprintf("feature-%d", i+1)
+		prs[i].Base.Ref = "main"
+	}
+
+	paginate := func(w http.ResponseWriter, r *http.Request, total int, page func(from, to int) interface{}) {
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		n, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		if limit <= 0 || n <= 0 {
+			t.Errorf("%s isn't paginated", r.URL)
+			return
+		}
+
+		from, to := (n-1)*limit, n*limit
+		if from > total {
+			from = total
+		}
+		if to > total {
+			to = total
+		}
+		w.Header().Set("X-Total-Count", strconv.Itoa(total))
+		if to < total {
+			q := r.URL.Query()
+			q.Set("page", strconv.Itoa(n+1))
+			w.Header().Set("Link", fmt.Sprintf(`<%s?%s>; rel="next"`, r.URL.Path, q.Encode()))
+		}
+		json.NewEncoder(w).Encode(page(from, to))
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/org/repo/pulls", func(w http.ResponseWriter, r *http.Request) {
+		paginate(w, r, len(prs), func(from, to int) interface{} { return prs[from:to] })
+	})
+	mux.HandleFunc("/repos/org/repo/pulls/", func(w http.ResponseWriter, r *http.Request) {
+		var id int
+		fmt.Sscanf(r.URL.Path, "/repos/org/repo/pulls/%d", &id)
+		if id < 1 || id > len(prs) {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(prs[id-1])
+	})
+	mux.HandleFunc("/repos/issues/search", func(w http.ResponseWriter, r *http.Request) {
+		// the PRs created by the user are also assigned to them
+		var issues []giteaIssue
+		for _, pr := range prs {
+			q := r.URL.Query()
+			if (q.Get("created") != "" || q.Get("assigned") != "") && pr.User.Login != "me" {
+				continue
+			}
+			if q.Get("review_requested") != "" {
+				continue
+			}
+			issue := giteaIssue{Number: pr.Number}
+			issue.Repository.FullName = "org/repo"
+			issues = append(issues, issue)
+		}
+		paginate(w, r, len(issues), func(from, to int) interface{} { return issues[from:to] })
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestGiteaSearchPRs(t *testing.T) {
+	srv := giteaServer(t, 120)
+	repo := repository{host: "gitea.example.com", owner: "org", name: "repo", path: "org/repo", provider: "gitea"}
+
+	tests := []struct {
+		name  string
+		conf  Conf
+		shown int
+		count int
+	}{
+		{"everyone", Conf{Everyone: true}, 120, 120},
+		{"everyone up to the limit", Conf{Everyone: true, Limit: 60}, 60, 120},
+		{"involves", Conf{User: "me"}, 40, 40},
+		{"involves up to the limit", Conf{User: "me", Limit: 10}, 10, 40},
+		{"all repos", Conf{All: true, Everyone: true, Limit: 70}, 70, 120},
+		{"all repos involves", Conf{All: true, User: "me"}, 40, 40},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.conf.Hosts = map[string]HostConf{repo.host: {API: srv.URL}}
+
+			var search PRsSearch
+			err := newGitea(repo, tt.conf).SearchPRs(tt.conf, func(page PRsSearch) error {
+				search.IssueCount = page.IssueCount
+				search.Nodes = append(search.Nodes, page.Nodes...)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(search.Nodes) != tt.shown || search.IssueCount != tt.count {
+				t.Errorf("got %d of %d PRs, want %d of %d", len(search.Nodes), search.IssueCount, tt.shown, tt.count)
+			}
+			seen := map[int]bool{}
+			for _, pr := range search.Nodes {
+				if seen[pr.Number] {
+					t.Errorf("PR #%d listed twice", pr.Number)
+				}
+				seen[pr.Number] = true
+				if !tt.conf.Everyone && pr.Author.Login != "me" {
+					t.Errorf("PR #%d doesn't involve the user", pr.Number)
+				}
+			}
+		})
+	}
+}
+
+func TestGiteaPRRefs(t *testing.T) {
+	srv := giteaServer(t, 10)
+	repo := repository{host: "gitea.example.com", owner: "org", name: "repo", path: "org/repo", provider: "gitea"}
+	conf := Conf{Hosts: map[string]HostConf{repo.host: {API: srv.URL + "/"}}}
+
+	base, head, err := newGitea(repo, conf).PRRefs(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if base != "main" || head != "feature-7" {
+		t.Errorf("got refs %s and %s, want main and feature-7", base, head)
+	}
+
+	if _, _, err := newGitea(repo, conf).PRRefs(11); err == nil {
+		t.Error("expected an error for a missing PR")
+	}
+}
@@ -0,0 +1,335 @@
+package castor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/machinebox/graphql"
+)
+
+// gitHub implements Provider using GitHub's GraphQL API (v4).
+type gitHub struct {
+	client *graphql.Client
+	repo   repository
+	token  string
+}
+
+func newGitHub(repo repository, conf Conf) Provider {
+	host := conf.host(repo.host)
+	api := host.API
+	if api == "" {
+		api = gitHubAPI(repo.host)
+	}
+
+	return &gitHub{
+		client: graphql.NewClient(api, graphql.WithHTTPClient(httpClient)),
+		repo:   repo,
+		token:  host.Token,
+	}
+}
+
+// gitHubAPI returns the GraphQL endpoint of a GitHub host, GitHub Enterprise
+// Server instances serve it under /api/graphql.
+func gitHubAPI(host string) string {
+	if host == "" || host == "github.com" {
+		return "https://api.github.com/graphql"
+	}
+	return "https://" + host + "/api/graphql"
+}
+
+func (gh *gitHub) SearchPRs(conf Conf, page func(PRsSearch) error) error {
+	search := gh.searchQuery(conf)
+
+	// TODO: closed vs merged
+	if conf.Closed && !conf.Open {
+		search = append(search, "is:closed")
+	}
+	if conf.Open && !conf.Closed {
+		search = append(search, "is:open")
+	}
+
+	return gh.searchPRs(strings.Join(search, " "), conf.Limit, page)
+}
+
+// searchQuery returns the qualifiers of the search of conf, but the state.
+func (gh *gitHub) searchQuery(conf Conf) []string {
+	search := []string{"type:pr"}
+
+	if !conf.All {
+		search = append(search, "repo:"+gh.repo.owner+"/"+gh.repo.name)
+	}
+	if !conf.Everyone {
+		// TODO: involves vs author
+		search = append(search, "involves:"+conf.User)
+	}
+
+	return search
+}
+
+// updatedSince searches the PRs updated since t in any state, so the ones
+// that got closed are found too.
+func (gh *gitHub) updatedSince(conf Conf, t time.Time) ([]SearchPR, bool, error) {
+	search := append(gh.searchQuery(conf), "updated:>="+t.UTC().Format(time.RFC3339))
+
+	var prs []SearchPR
+	complete := false
+	err := gh.searchPRs(strings.Join(search, " "), searchPageSize, func(page PRsSearch) error {
+		prs = page.Nodes
+		complete = !page.PageInfo.HasNextPage
+		return nil
+	})
+
+	return prs, complete, err
+}
+
+var rateLimitFields = `
+rateLimit {
+  limit
+  cost
+  remaining
+  resetAt
+}
+`
+
+var prBranchNameQuery = `
+query repoBranchName($owner: String!, $name:String!, $pr:Int!) {
+  repository(owner: $owner, name: $name) {
+    pullRequest(number:$pr) {
+      headRefName
+	  baseRefName
+    }
+  }
+  ` + rateLimitFields + `
+}
+`
+
+func (gh *gitHub) PRRefs(id int) (string, string, error) {
+	req := gh.request(prBranchNameQuery)
+	req.Var("owner", gh.repo.owner)
+	req.Var("name", gh.repo.name)
+	req.Var("pr", id)
+
+	var res struct {
+		Repository struct {
+			PullRequest struct {
+				BaseRefName string `json:"baseRefName"`
+				HeadRefName string `json:"headRefName"`
+			} `json:"pullRequest"`
+		} `json:"repository"`
+		RateLimit RateLimit `json:"rateLimit"`
+	}
+
+	ctx := context.Background()
+
+	if err := gh.client.Run(ctx, req, &res); err != nil {
+		return "", "", err
+	}
+	warnRateLimit(res.RateLimit)
+
+	base := res.Repository.PullRequest.BaseRefName
+	head := res.Repository.PullRequest.HeadRefName
+
+	return base, head, nil
+}
+
+var prFields = `
+number
+title
+url
+author {
+  login
+}
+headRepository {
+  name
+}
+headRepositoryOwner {
+  login
+}
+closed
+merged
+headRefName
+baseRefName
+createdAt
+updatedAt
+labels(first: 20) {
+  totalCount
+  nodes {
+	name
+	color
+  }
+}
+reviewRequests(first: 20) {
+  totalCount
+  nodes {
+	requestedReviewer {
+	  ... on User {
+		login
+	  }
+	  ... on Team {
+		name
+	  }
+	}
+  }
+}
+`
+
+var prNodes = `
+nodes {
+  ... on PullRequest {
+	` + prFields + `
+  }
+}
+`
+
+var prQuery = `
+query pr($owner: String!, $name:String!, $pr:Int!) {
+  repository(owner: $owner, name: $name) {
+    pullRequest(number:$pr) {
+      ` + prFields + `
+    }
+  }
+  ` + rateLimitFields + `
+}
+`
+
+func (gh *gitHub) PR(id int) (SearchPR, error) {
+	req := gh.request(prQuery)
+	req.Var("owner", gh.repo.owner)
+	req.Var("name", gh.repo.name)
+	req.Var("pr", id)
+
+	var res struct {
+		Repository struct {
+			PullRequest SearchPR `json:"pullRequest"`
+		} `json:"repository"`
+		RateLimit RateLimit `json:"rateLimit"`
+	}
+	ctx := context.Background()
+
+	if err := gh.client.Run(ctx, req, &res); err != nil {
+		return SearchPR{}, err
+	}
+	warnRateLimit(res.RateLimit)
+
+	return res.Repository.PullRequest, nil
+}
+
+func (gh *gitHub) HeadRef(n int) string {
+	return fmt.Sprintf("refs/pull/%d/head", n)
+}
+
+var listPRsQuery = `
+query search($query: String!, $first: Int!, $after: String) {
+  search(query: $query, type: ISSUE, first: $first, after: $after) {
+    issueCount
+    pageInfo {
+      hasNextPage
+      endCursor
+    }
+    ` + prNodes + `
+  }
+  ` + rateLimitFields + `
+}
+`
+
+// searchPageSize is the max amount of nodes GitHub returns per page.
+const searchPageSize = 100
+
+// searchPRs follows the search cursor until there are no more pages or
+// limit PRs are fetched (no limit when 0).
+func (gh *gitHub) searchPRs(searchQuery string, limit int, page func(PRsSearch) error) error {
+	var after *string
+	fetched := 0
+
+	for {
+		first := searchPageSize
+		if limit > 0 && limit-fetched < first {
+			first = limit - fetched
+		}
+
+		req := gh.request(listPRsQuery)
+		req.Var("query", searchQuery)
+		req.Var("first", first)
+		req.Var("after", after)
+
+		var res struct {
+			Search    PRsSearch `json:"search"`
+			RateLimit RateLimit `json:"rateLimit"`
+		}
+		ctx := context.Background()
+
+		if err := gh.client.Run(ctx, req, &res); err != nil {
+			return err
+		}
+		warnRateLimit(res.RateLimit)
+
+		if err := page(res.Search); err != nil {
+			return err
+		}
+
+		fetched += len(res.Search.Nodes)
+		info := res.Search.PageInfo
+		if !info.HasNextPage || len(res.Search.Nodes) == 0 || (limit > 0 && fetched >= limit) {
+			return nil
+		}
+		after = &info.EndCursor
+	}
+}
+
+var rateLimitQuery = `
+query rateLimit {
+  viewer {
+    login
+  }
+  ` + rateLimitFields + `
+}
+`
+
+// RateLimit returns the status of the GitHub API rate limit of the token.
+func (gh *gitHub) RateLimit() (RateLimit, error) {
+	req := gh.request(rateLimitQuery)
+
+	var res struct {
+		Viewer    Login     `json:"viewer"`
+		RateLimit RateLimit `json:"rateLimit"`
+	}
+	ctx := context.Background()
+
+	if err := gh.client.Run(ctx, req, &res); err != nil {
+		return RateLimit{}, err
+	}
+
+	res.RateLimit.User = res.Viewer.Login
+	return res.RateLimit, nil
+}
+
+var rateLimitWarned = false
+
+// warnRateLimit warns once when less than 10% of the rate limit remains.
+func warnRateLimit(rl RateLimit) {
+	if rateLimitWarned || rl.Limit == 0 || rl.Remaining*10 >= rl.Limit {
+		return
+	}
+	rateLimitWarned = true
+
+	fmt.Fprintf(
+		os.Stderr,
+		"Warning: only %d of %d GitHub API points left, they reset at %s\n\n",
+		rl.Remaining,
+		rl.Limit,
+		rl.ResetAt.Local().Format("15:04:05"),
+	)
+}
+
+func (gh *gitHub) request(query string) *graphql.Request {
+	req := graphql.NewRequest(query)
+
+	if gh.token != "" {
+		req.Header.Set("Authorization", "token "+gh.token)
+	}
+
+	return req
+}
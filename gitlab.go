@@ -0,0 +1,296 @@
+package castor
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// gitLab implements Provider using GitLab's REST API (v4), mapping merge
+// requests into PRs.
+type gitLab struct {
+	api   string
+	repo  repository
+	token string
+}
+
+func newGitLab(repo repository, conf Conf) Provider {
+	host := conf.host(repo.host)
+	api := host.API
+	if api == "" {
+		api = "https://" + repo.host + "/api/v4"
+	}
+
+	return &gitLab{api: strings.TrimSuffix(api, "/"), repo: repo, token: host.Token}
+}
+
+type glMR struct {
+	IID          int       `json:"iid"`
+	ProjectID    int       `json:"project_id"`
+	Title        string    `json:"title"`
+	WebURL       string    `json:"web_url"`
+	State        string    `json:"state"`
+	SourceBranch string    `json:"source_branch"`
+	TargetBranch string    `json:"target_branch"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	Author       struct {
+		Username string `json:"username"`
+	} `json:"author"`
+	Reviewers []struct {
+		Username string `json:"username"`
+	} `json:"reviewers"`
+	Labels     []glLabel `json:"labels"`
+	References struct {
+		Full string `json:"full"`
+	} `json:"references"`
+}
+
+// glLabel is a label of a MR, GitLab only sends its name unless the MRs are
+// listed `with_labels_details`.
+type glLabel struct {
+	Name  string `json:"name"`
+	Color string `json:"color"`
+}
+
+func (l *glLabel) UnmarshalJSON(b []byte) error {
+	if len(b) > 0 && b[0] == '"' {
+		*l = glLabel{}
+		return json.Unmarshal(b, &l.Name)
+	}
+
+	// the alias doesn't have the UnmarshalJSON method
+	type label glLabel
+	return json.Unmarshal(b, (*label)(l))
+}
+
+type glApprovals struct {
+	ApprovedBy []struct {
+		User struct {
+			Username string `json:"username"`
+		} `json:"user"`
+	} `json:"approved_by"`
+}
+
+func (gl *gitLab) SearchPRs(conf Conf, page func(PRsSearch) error) error {
+	endpoint, filters := gl.search(conf)
+
+	// the closed MRs are filtered locally, so they can't be counted by GitLab
+	closedOnly := conf.Closed && !conf.Open
+	seen := map[string]bool{}
+	shown, count := 0, 0
+	for _, filter := range filters {
+		filter.Set("scope", "all")
+		filter.Set("state", glState(conf))
+		filter.Set("with_labels_details", "true")
+		filter.Set("per_page", "100")
+
+		for next := "1"; next != ""; {
+			filter.Set("page", next)
+
+			var mrs []glMR
+			header, err := gl.getHeader(endpoint+"?"+filter.Encode(), &mrs)
+			if err != nil {
+				return err
+			}
+
+			search := PRsSearch{}
+			for _, mr := range mrs {
+				if seen[mr.WebURL] {
+					continue
+				}
+				seen[mr.WebURL] = true
+
+				if closedOnly && mr.State != "closed" && mr.State != "merged" {
+					continue
+				}
+				count++
+				if conf.Limit > 0 && shown >= conf.Limit {
+					continue
+				}
+				search.Nodes = append(search.Nodes, gl.toPR(mr))
+				shown++
+			}
+			search.IssueCount = count
+
+			// GitLab omits X-Total for large counts
+			total, err := strconv.Atoi(header.Get("X-Total"))
+			counted := err == nil && len(filters) == 1 && !closedOnly
+			if counted {
+				search.IssueCount = total
+			}
+
+			if err := page(search); err != nil {
+				return err
+			}
+			if counted && conf.Limit > 0 && shown >= conf.Limit {
+				return nil
+			}
+			next = header.Get("X-Next-Page")
+		}
+	}
+
+	return nil
+}
+
+// search returns the endpoint listing the MRs of conf and the queries to
+// list them with. GitLab has no `involves`, so both the authored and the
+// to-review MRs of the user are queried.
+func (gl *gitLab) search(conf Conf) (string, []url.Values) {
+	endpoint := gl.project() + "/merge_requests"
+	if conf.All {
+		endpoint = "/merge_requests"
+	}
+
+	filters := []url.Values{{}}
+	if !conf.Everyone {
+		filters = []url.Values{
+			{"author_username": {conf.User}},
+			{"reviewer_username": {conf.User}},
+		}
+	}
+
+	return endpoint, filters
+}
+
+// updatedSince lists the MRs updated since t in any state, so the ones that
+// got closed are found too.
+func (gl *gitLab) updatedSince(conf Conf, t time.Time) ([]SearchPR, bool, error) {
+	endpoint, filters := gl.search(conf)
+
+	var prs []SearchPR
+	for _, filter := range filters {
+		filter.Set("scope", "all")
+		filter.Set("state", "all")
+		filter.Set("updated_after", t.UTC().Format(time.RFC3339))
+		filter.Set("with_labels_details", "true")
+		filter.Set("per_page", "100")
+
+		var mrs []glMR
+		header, err := gl.getHeader(endpoint+"?"+filter.Encode(), &mrs)
+		if err != nil {
+			return nil, false, err
+		}
+		if header.Get("X-Next-Page") != "" {
+			return nil, false, nil
+		}
+
+		for _, mr := range mrs {
+			prs = append(prs, SearchPR{URL: mr.WebURL, UpdatedAt: mr.UpdatedAt})
+		}
+	}
+
+	return prs, true, nil
+}
+
+// glState maps the --open and --closed flags to the `state` of GitLab MRs,
+// GitLab's `closed` excludes merged MRs so those are filtered afterwards.
+func glState(conf Conf) string {
+	if conf.Open && !conf.Closed {
+		return "opened"
+	}
+	return "all"
+}
+
+func (gl *gitLab) PRRefs(iid int) (string, string, error) {
+	mr, err := gl.mr(iid)
+	if err != nil {
+		return "", "", err
+	}
+
+	return mr.TargetBranch, mr.SourceBranch, nil
+}
+
+func (gl *gitLab) PR(iid int) (SearchPR, error) {
+	mr, err := gl.mr(iid)
+	if err != nil {
+		return SearchPR{}, err
+	}
+
+	return gl.toPR(mr), nil
+}
+
+func (gl *gitLab) HeadRef(n int) string {
+	return fmt.Sprintf("refs/merge-requests/%d/head", n)
+}
+
+func (gl *gitLab) mr(iid int) (glMR, error) {
+	var mr glMR
+	err := gl.get(fmt.Sprintf("%s/merge_requests/%d", gl.project(), iid), &mr)
+	return mr, err
+}
+
+// toPR maps a MR into a PR, its review requests are the reviewers that
+// haven't approved it yet. When the approvals can't be fetched (e.g. the
+// token can't read them) all the reviewers are review requests.
+func (gl *gitLab) toPR(mr glMR) SearchPR {
+	var approvals glApprovals
+	gl.get(fmt.Sprintf("/projects/%d/merge_requests/%d/approvals", mr.ProjectID, mr.IID), &approvals)
+
+	approved := map[string]bool{}
+	for _, a := range approvals.ApprovedBy {
+		approved[a.User.Username] = true
+	}
+
+	pr := SearchPR{
+		URL:         mr.WebURL,
+		Number:      mr.IID,
+		Title:       mr.Title,
+		Author:      WithLogin{mr.Author.Username},
+		HeadRefName: mr.SourceBranch,
+		BaseRefName: mr.TargetBranch,
+		Closed:      mr.State == "closed" || mr.State == "merged",
+		Merged:      mr.State == "merged",
+		CreatedAt:   mr.CreatedAt,
+		UpdatedAt:   mr.UpdatedAt,
+	}
+
+	project := strings.SplitN(mr.References.Full, "!", 2)[0]
+	pr.HeadRepositoryOwner.Login = path.Dir(project)
+	pr.HeadRepository.Name = path.Base(project)
+
+	for _, l := range mr.Labels {
+		pr.Labels.Nodes = append(pr.Labels.Nodes, Label{l.Name, strings.TrimPrefix(l.Color, "#")})
+	}
+	pr.Labels.TotalCount = len(pr.Labels.Nodes)
+
+	for _, r := range mr.Reviewers {
+		if approved[r.Username] {
+			continue
+		}
+		pr.ReviewRequests.Nodes = append(
+			pr.ReviewRequests.Nodes,
+			RequestedReviewer{RequestedReviewer: LoginAndName{Login: r.Username}},
+		)
+	}
+	pr.ReviewRequests.TotalCount = len(pr.ReviewRequests.Nodes)
+
+	return pr
+}
+
+// project returns the API path of the repo, GitLab accepts the URL encoded
+// path of the project as its ID.
+func (gl *gitLab) project() string {
+	return "/projects/" + url.PathEscape(gl.repo.path)
+}
+
+func (gl *gitLab) get(endpoint string, v interface{}) error {
+	_, err := gl.getHeader(endpoint, v)
+	return err
+}
+
+// getHeader is get returning the header of the response, GitLab paginates
+// with X-Next-Page.
+func (gl *gitLab) getHeader(endpoint string, v interface{}) (http.Header, error) {
+	header := http.Header{}
+	if gl.token != "" {
+		header.Set("PRIVATE-TOKEN", gl.token)
+	}
+
+	return getJSONHeader(gl.api+endpoint, header, v)
+}
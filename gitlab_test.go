@@ -0,0 +1,190 @@
+package castor
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// gitLabServer serves n labelled MRs of org/repo, paginated as GitLab does,
+// every fifth of them authored by `me`. Their approvals can't be read.
+func gitLabServer(t *testing.T, n int) *httptest.Server {
+	updated := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// mr returns MR i as GitLab does, with the details of its labels only
+	// when listed `with_labels_details`
+	mr := func(i int, details bool) map[string]interface{} {
+		author := "someone"
+		if i%5 == 0 {
+			author = "me"
+		}
+		var labels interface{} = []string{"bug"}
+		if details {
+			labels = []map[string]string{{"name": "bug", "color": "#ff0000"}}
+		}
+
+		return map[string]interface{}{
+			"iid":           i,
+			"project_id":    1,
+			"title":         fmt.Sprintf("MR %d", i),
+			"web_url":       fmt.Sprintf("https://gitlab.example.com/org/repo/-/merge_requests/%d", i),
+			"state":         "opened",
+			"source_branch": fmt.Sprintf("feature-%d", i),
+			"target_branch": "main",
+			"updated_at":    updated.Add(time.Duration(i) * time.Minute),
+			"author":        map[string]string{"username": author},
+			"reviewers":     []map[string]string{{"username": "reviewer"}},
+			"labels":        labels,
+			"references":    map[string]string{"full": fmt.Sprintf("org/repo!%d", i)},
+		}
+	}
+
+	list := func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		details := q.Get("with_labels_details") == "true"
+		since, _ := time.Parse(time.RFC3339, q.Get("updated_after"))
+
+		var mrs []map[string]interface{}
+		for i := 1; i <= n; i++ {
+			m := mr(i, details)
+			if u := q.Get("author_username"); u != "" && m["author"].(map[string]string)["username"] != u {
+				continue
+			}
+			if q.Get("reviewer_username") == "me" {
+				continue
+			}
+			if m["updated_at"].(time.Time).Before(since) {
+				continue
+			}
+			mrs = append(mrs, m)
+		}
+
+		perPage, _ := strconv.Atoi(q.Get("per_page"))
+		page, _ := strconv.Atoi(q.Get("page"))
+		if perPage <= 0 {
+			perPage = 20
+		}
+		if page <= 0 {
+			page = 1
+		}
+		from, to := (page-1)*perPage, page*perPage
+		if from > len(mrs) {
+			from = len(mrs)
+		}
+		if to > len(mrs) {
+			to = len(mrs)
+		}
+		if to < len(mrs) {
+			w.Header().Set("X-Next-Page", strconv.Itoa(page+1))
+		}
+		w.Header().Set("X-Total", strconv.Itoa(len(mrs)))
+		json.NewEncoder(w).Encode(mrs[from:to])
+	}
+
+	// the project is in the path URL encoded, so the paths are matched
+	// escaped
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var i int
+		path := r.URL.EscapedPath()
+		fmt.Sscanf(path, "/projects/org%%2Frepo/merge_requests/%d", &i)
+
+		switch {
+		case path == "/projects/org%2Frepo/merge_requests":
+			list(w, r)
+		case strings.HasPrefix(path, "/projects/1/merge_requests/"):
+			http.Error(w, "403 Forbidden", http.StatusForbidden)
+		case i >= 1 && i <= n:
+			json.NewEncoder(w).Encode(mr(i, false))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+var gitLabRepo = repository{host: "gitlab.example.com", owner: "org", name: "repo", path: "org/repo", provider: "gitlab"}
+
+func TestGitLabSearchPRs(t *testing.T) {
+	srv := gitLabServer(t, 250)
+
+	tests := []struct {
+		name  string
+		conf  Conf
+		shown int
+		count int
+	}{
+		{"everyone", Conf{Everyone: true}, 250, 250},
+		{"everyone up to the limit", Conf{Everyone: true, Limit: 120}, 120, 250},
+		{"involves", Conf{User: "me"}, 50, 50},
+		{"involves up to the limit", Conf{User: "me", Limit: 5}, 5, 50},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.conf.Hosts = map[string]HostConf{gitLabRepo.host: {API: srv.URL}}
+
+			var search PRsSearch
+			err := newGitLab(gitLabRepo, tt.conf).SearchPRs(tt.conf, func(page PRsSearch) error {
+				search.IssueCount = page.IssueCount
+				search.Nodes = append(search.Nodes, page.Nodes...)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(search.Nodes) != tt.shown || search.IssueCount != tt.count {
+				t.Fatalf("got %d of %d MRs, want %d of %d", len(search.Nodes), search.IssueCount, tt.shown, tt.count)
+			}
+			pr := search.Nodes[0]
+			if len(pr.Labels.Nodes) != 1 || pr.Labels.Nodes[0] != (Label{"bug", "ff0000"}) {
+				t.Errorf("got labels %+v, want bug in ff0000", pr.Labels.Nodes)
+			}
+			// the approvals can't be read, so the reviewer is still requested
+			if pr.ReviewRequests.TotalCount != 1 {
+				t.Errorf("got %d review requests, want 1", pr.ReviewRequests.TotalCount)
+			}
+		})
+	}
+}
+
+func TestGitLabPRRefs(t *testing.T) {
+	srv := gitLabServer(t, 10)
+	conf := Conf{Hosts: map[string]HostConf{gitLabRepo.host: {API: srv.URL}}}
+
+	base, head, err := newGitLab(gitLabRepo, conf).PRRefs(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if base != "main" || head != "feature-7" {
+		t.Errorf("got refs %s and %s, want main and feature-7", base, head)
+	}
+
+	pr, err := newGitLab(gitLabRepo, conf).PR(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pr.Labels.Nodes) != 1 || pr.Labels.Nodes[0].Name != "bug" {
+		t.Errorf("got labels %+v, want bug", pr.Labels.Nodes)
+	}
+}
+
+func TestGitLabUpdatedSince(t *testing.T) {
+	srv := gitLabServer(t, 10)
+	conf := Conf{Everyone: true, Hosts: map[string]HostConf{gitLabRepo.host: {API: srv.URL}}}
+
+	since := time.Date(2024, 1, 1, 0, 8, 0, 0, time.UTC)
+	prs, ok, err := newGitLab(gitLabRepo, conf).(*gitLab).updatedSince(conf, since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || len(prs) != 3 {
+		t.Errorf("got %d MRs updated since %s, want 3", len(prs), since)
+	}
+}
@@ -0,0 +1,114 @@
+//go:build gogit
+// +build gogit
+
+package castor
+
+import (
+	"sort"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/osfs"
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+)
+
+// Building with `-tags gogit` reads the repository state with go-git.
+func init() {
+	git = goGit{}
+}
+
+// goGit implements GitRunner reading the repository state with go-git, it runs
+// the git executable for the commands go-git doesn't support (e.g. stash).
+type goGit struct {
+	execGit
+}
+
+// Status falls back to the git executable when go-git can't open the repo,
+// e.g. it rejects the `refs/pull/N/head` upstreams of the castor branches,
+// for repos with submodules, whose changes go-git doesn't report, and when
+// there are untracked files, which git may ignore.
+func (g goGit) Status() (RepoStatus, error) {
+	repo, err := gogit.PlainOpenWithOptions(".", &gogit.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		return g.execGit.Status()
+	}
+
+	var status RepoStatus
+
+	head, err := repo.Reference(plumbing.HEAD, false)
+	if err != nil {
+		return RepoStatus{}, err
+	}
+	switch {
+	case head.Type() == plumbing.SymbolicReference:
+		status.Branch = head.Target().Short()
+	default:
+		status.Branch = "(detached)"
+	}
+
+	resolved, err := repo.Head()
+	switch {
+	case err == plumbing.ErrReferenceNotFound:
+		status.Head = "(initial)"
+	case err != nil:
+		return RepoStatus{}, err
+	default:
+		status.Head = resolved.Hash().String()
+	}
+
+	wt, err := repo.Worktree()
+	if err != nil {
+		return RepoStatus{}, err
+	}
+	if subs, err := wt.Submodules(); err != nil || len(subs) > 0 {
+		return g.execGit.Status()
+	}
+	// go-git doesn't read the excludes files of the git config by itself
+	root := osfs.New("/")
+	for _, load := range []func(billy.Filesystem) ([]gitignore.Pattern, error){gitignore.LoadSystemPatterns, gitignore.LoadGlobalPatterns} {
+		if ps, err := load(root); err == nil {
+			wt.Excludes = append(wt.Excludes, ps...)
+		}
+	}
+	files, err := wt.Status()
+	if err != nil {
+		return RepoStatus{}, err
+	}
+	// git may still ignore untracked files by patterns go-git doesn't read
+	// (e.g. in ~/.config/git/ignore), so git lists them instead
+	for _, f := range files {
+		if f.Worktree == gogit.Untracked {
+			return g.execGit.Status()
+		}
+	}
+
+	for path, f := range files {
+		e := StatusEntry{Kind: '1', XY: goGitCode(f.Staging) + goGitCode(f.Worktree), Sub: "N...", Path: path}
+		switch {
+		case f.Worktree == gogit.Untracked:
+			e = StatusEntry{Kind: '?', Path: path}
+		case f.Staging == gogit.UpdatedButUnmerged || f.Worktree == gogit.UpdatedButUnmerged:
+			e.Kind = 'u'
+		case f.Staging == gogit.Renamed || f.Staging == gogit.Copied:
+			e.Kind = '2'
+			e.OrigPath = f.Extra
+		}
+		status.Entries = append(status.Entries, e)
+	}
+	sort.Slice(status.Entries, func(i, j int) bool {
+		return status.Entries[i].Path < status.Entries[j].Path
+	})
+	detectState(g.execGit, &status)
+
+	return status, nil
+}
+
+// goGitCode maps a go-git status code to the porcelain v2 one, which uses `.`
+// for unmodified.
+func goGitCode(c gogit.StatusCode) string {
+	if c == gogit.Unmodified {
+		return "."
+	}
+	return string(c)
+}
@@ -0,0 +1,163 @@
+package castor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var httpClient = &http.Client{Transport: retryTransport{http.DefaultTransport}}
+
+// getJSON requests url and decodes the JSON response body into v.
+func getJSON(url string, header http.Header, v interface{}) error {
+	_, err := getJSONHeader(url, header, v)
+	return err
+}
+
+// getJSONHeader is getJSON returning the header of the response too, which
+// REST APIs paginate with (e.g. Link or X-Next-Page).
+func getJSONHeader(url string, header http.Header, v interface{}) (http.Header, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, fmt.Errorf("Request to %s failed: %s", url, res.Status)
+	}
+
+	return res.Header, json.NewDecoder(res.Body).Decode(v)
+}
+
+// maxRetries is the amount of times a request is retried after hitting a
+// secondary rate limit or a transient server error.
+const maxRetries = 3
+
+// retryTransport retries requests that hit a secondary rate limit or a
+// transient server error (502, 503 and 504) with exponential backoff, and
+// turns exhausted rate limits into errors with the time they reset.
+type retryTransport struct {
+	http.RoundTripper
+}
+
+func (t retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	backoff := time.Second
+
+	// retries send a clone of req, a RoundTripper can't modify it
+	r := req
+	for attempt := 0; ; attempt++ {
+		res, err := t.RoundTripper.RoundTrip(r)
+		if err != nil {
+			return nil, err
+		}
+
+		if isRateLimited(res) {
+			res.Body.Close()
+			return nil, fmt.Errorf("API rate limit exceeded, it resets at %s", rateLimitReset(res))
+		}
+
+		wait, retry := retryAfter(res, backoff)
+		if !retry || attempt == maxRetries || (req.Body != nil && req.GetBody == nil) {
+			return res, nil
+		}
+		res.Body.Close()
+
+		fmt.Fprintf(os.Stderr, "%s: %s, retrying in %s\n", req.URL.Host, res.Status, wait)
+		time.Sleep(wait)
+		backoff *= 2
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			r = req.Clone(req.Context())
+			r.Body = body
+		}
+	}
+}
+
+// maxRetryAfter caps the time waited on a Retry-After header.
+const maxRetryAfter = time.Minute
+
+// retryAfter tells if the request of res should be retried and how long to
+// wait for it, using the Retry-After header or backoff otherwise.
+func retryAfter(res *http.Response, backoff time.Duration) (time.Duration, bool) {
+	switch res.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+	case http.StatusForbidden, http.StatusTooManyRequests:
+		if res.Header.Get("Retry-After") == "" && !isSecondaryRateLimit(res) {
+			return 0, false
+		}
+	default:
+		return 0, false
+	}
+
+	if secs, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil {
+		wait := time.Duration(secs) * time.Second
+		if wait > maxRetryAfter {
+			wait = maxRetryAfter
+		}
+		return wait, true
+	}
+
+	return backoff, true
+}
+
+// isRateLimited tells if res was refused for exhausting the rate limit. REST
+// APIs refuse with 403 or 429, while GitHub's GraphQL API answers with 200
+// and a RATE_LIMITED error.
+func isRateLimited(res *http.Response) bool {
+	if res.Header.Get("X-RateLimit-Remaining") != "0" {
+		return false
+	}
+	if res.StatusCode == http.StatusForbidden || res.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+
+	return res.StatusCode == http.StatusOK && strings.Contains(peekBody(res), `"RATE_LIMITED"`)
+}
+
+// isSecondaryRateLimit peeks the body of res for GitHub's secondary rate limit
+// message, leaving the body intact.
+func isSecondaryRateLimit(res *http.Response) bool {
+	return strings.Contains(strings.ToLower(peekBody(res)), "secondary rate limit")
+}
+
+// peekBody returns the start of the body of res, leaving the body intact.
+func peekBody(res *http.Response) string {
+	b, err := ioutil.ReadAll(io.LimitReader(res.Body, 4096))
+	res.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(strings.NewReader(string(b)), res.Body), res.Body}
+	if err != nil {
+		return ""
+	}
+
+	return string(b)
+}
+
+func rateLimitReset(res *http.Response) string {
+	secs, err := strconv.ParseInt(res.Header.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return "an unknown time"
+	}
+	return time.Unix(secs, 0).Format("15:04:05")
+}
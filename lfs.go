@@ -0,0 +1,104 @@
+package castor
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The ways castor fetches the Git LFS objects of a PR, set with
+// `castor config --lfs` or `git config castor.lfs` for a single repo.
+const (
+	// lfsChanged fetches only the objects of the files the PR changed, the
+	// others are left as pointer files.
+	lfsChanged = "changed"
+	// lfsAll lets git-lfs fetch the objects of all the files, as a plain
+	// `git checkout` does.
+	lfsAll = "all"
+	// lfsOff doesn't fetch any object.
+	lfsOff = "off"
+)
+
+// lfsMode returns how to fetch the LFS objects of the PRs, the `--lfs` flag
+// takes precedence over the `castor.lfs` git config of the repo, which does
+// over conf.LFS.
+func lfsMode(conf Conf) (string, error) {
+	mode := conf.LFSFlag
+	if mode == "" {
+		mode, _ = git.Output("config", "castor.lfs")
+	}
+	if mode == "" {
+		mode = conf.LFS
+	}
+
+	switch mode {
+	case "":
+		return lfsChanged, nil
+	case lfsChanged, lfsAll, lfsOff:
+		return mode, nil
+	}
+	return "", fmt.Errorf("Unknown LFS mode `%s`, use `changed`, `all` or `off`", mode)
+}
+
+// hasLFS tells if git-lfs is installed.
+func hasLFS() bool {
+	return git.Run("lfs", "version") == nil
+}
+
+// lfsCheckoutArgs returns the git options that make a checkout skip fetching
+// LFS objects when they are fetched by pullLFS instead.
+func lfsCheckoutArgs(mode string) []string {
+	if mode == lfsAll || !hasLFS() {
+		return nil
+	}
+
+	return []string{
+		"-c", "filter.lfs.smudge=git-lfs smudge --skip -- %f",
+		"-c", "filter.lfs.process=git-lfs filter-process --skip",
+	}
+}
+
+// lfsChangedFiles returns the LFS files changed between base, in the remote
+// or locally, and branch. It uses the .gitattributes of the working tree in
+// dir, so it must run once branch is checked out.
+func lfsChangedFiles(dir, remote, base, branch string) ([]string, error) {
+	var out string
+	var err error
+	for _, b := range []string{remote + "/" + base, base} {
+		out, err = git.Output("-C", dir, "diff", "--name-only", "--no-renames", "--diff-filter=d", b+"..."+branch, "--", ":(attr:filter=lfs)")
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't find the files `%s` changed from `%s`", branch, base)
+	}
+
+	if out == "" {
+		return nil, nil
+	}
+	return strings.Split(out, "\n"), nil
+}
+
+// pullLFS fetches and checks out the LFS objects of the files the PR in
+// branch changed, in the working tree of dir.
+func pullLFS(dir, remote, base, branch, mode string) error {
+	if mode != lfsChanged {
+		return nil
+	}
+
+	files, err := lfsChangedFiles(dir, remote, base, branch)
+	if err != nil || len(files) == 0 {
+		return err
+	}
+	if !hasLFS() {
+		fmt.Printf("\nThe PR changed %d Git LFS files but git-lfs isn't installed, they are pointer files\n", len(files))
+		return nil
+	}
+
+	fmt.Printf("\nFetching %d Git LFS files changed by the PR\n\n", len(files))
+	args := []string{"lfs", "pull", "--include", strings.Join(files, ",")}
+	if dir != "." {
+		args = append([]string{"-C", dir}, args...)
+	}
+	return git.RunWithPipe(args...)
+}
@@ -0,0 +1,301 @@
+package castor
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-isatty"
+)
+
+// PROutput is the schema of the PRs printed by `castor prs --output json`,
+// `ndjson` and `csv`. Fields are only ever added to it, never renamed or
+// removed, so scripts can rely on it.
+type PROutput struct {
+	Number int    `json:"number"`
+	URL    string `json:"url"`
+	// Repo is the head repository of the PR as `owner/name`.
+	Repo   string `json:"repo"`
+	Title  string `json:"title"`
+	Author string `json:"author"`
+	Branch string `json:"branch"`
+	Base   string `json:"base"`
+	// Status is `open`, `closed` or `merged`.
+	Status string `json:"status"`
+	// Reviewers are the users and teams whose review is still requested.
+	Reviewers []string  `json:"reviewers"`
+	Labels    []Label   `json:"labels"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func newPROutput(pr SearchPR) PROutput {
+	labels := pr.Labels.Nodes
+	if labels == nil {
+		labels = []Label{}
+	}
+
+	return PROutput{
+		Number:    pr.Number,
+		URL:       pr.URL,
+		Repo:      pr.HeadRepositoryOwner.Login + "/" + pr.HeadRepository.Name,
+		Title:     pr.Title,
+		Author:    pr.Author.Login,
+		Branch:    pr.HeadRefName,
+		Base:      pr.BaseRefName,
+		Status:    strings.ToLower(prStatus(pr)),
+		Reviewers: reviewers(pr),
+		Labels:    labels,
+		UpdatedAt: pr.UpdatedAt,
+		CreatedAt: pr.CreatedAt,
+	}
+}
+
+// prsFormat formats the PRs printed by prsOutput.
+type prsFormat interface {
+	// header is printed before the first PR.
+	header() error
+	row(pr SearchPR) error
+	// flush is called after each page of PRs.
+	flush() error
+	// footer is printed after the last page, shown of count PRs were printed.
+	footer(shown, count int) error
+}
+
+// prsGrouper is implemented by the formats that print groups of PRs.
+type prsGrouper interface {
+	// group starts a group of count PRs.
+	group(name string, count int) error
+}
+
+// prsOutput prints the PRs of a search in a format, a page at a time so large
+// listings show up while they are fetched. Sorted or grouped PRs are printed
+// at the end instead, once all of them are fetched, as are tables and JSON
+// arrays, counting the PRs fetched in stderr meanwhile.
+type prsOutput struct {
+	format  prsFormat
+	limit   int
+	sort    string
+	groupBy string
+	// buffered are the PRs kept to sort or group them.
+	buffered []SearchPR
+	// count is the total of PRs matching the search, shown the ones listed
+	// and printed the ones already printed.
+	count   int
+	shown   int
+	printed int
+	// progress tells if the PRs fetched are counted in stderr while they are
+	// fetched, for the outputs printed at the end.
+	progress bool
+}
+
+func newPRsOutput(conf Conf) (*prsOutput, error) {
+	if err := checkSort(conf); err != nil {
+		return nil, err
+	}
+
+	var format prsFormat
+	var err error
+	switch {
+	case conf.Format != "" && conf.Output != "" && conf.Output != "table":
+		return nil, fmt.Errorf("Use either --format or --output")
+	case conf.Format != "":
+		format, err = newPRsTemplate(conf.Format, conf)
+	case conf.Output == "", conf.Output == "table":
+		format, err = newPRsTable(conf)
+	case conf.Output == "json":
+		format = &prsJSON{prs: []PROutput{}}
+	case conf.Output == "ndjson":
+		format = prsNDJSON{json.NewEncoder(os.Stdout)}
+	case conf.Output == "csv":
+		format = prsCSV{csv.NewWriter(os.Stdout)}
+	default:
+		return nil, fmt.Errorf("Unknown output `%s`, use `table`, `json`, `ndjson` or `csv`", conf.Output)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	o := &prsOutput{format: format, limit: conf.Limit, sort: conf.Sort, groupBy: conf.GroupBy}
+	switch format.(type) {
+	case *prsTable, *prsJSON:
+		o.progress = true
+	default:
+		o.progress = o.buffer()
+	}
+	o.progress = o.progress && isatty.IsTerminal(os.Stderr.Fd())
+
+	return o, nil
+}
+
+// buffer tells if the PRs are printed at the end.
+func (o *prsOutput) buffer() bool {
+	return o.sort != "" || o.groupBy != ""
+}
+
+// page prints a page of PRs, up to limit PRs in total.
+func (o *prsOutput) page(search PRsSearch) error {
+	o.count = search.IssueCount
+
+	var prs []SearchPR
+	for _, pr := range search.Nodes {
+		if o.limit > 0 && o.shown >= o.limit {
+			break
+		}
+		prs = append(prs, pr)
+		o.shown++
+	}
+
+	if o.progress {
+		total := o.count
+		if o.limit > 0 && o.limit < total {
+			total = o.limit
+		}
+		fmt.Fprintf(os.Stderr, "\rFetched %d of %d PRs", o.shown, total)
+	}
+
+	if o.buffer() {
+		o.buffered = append(o.buffered, prs...)
+		return nil
+	}
+	return o.print(prs)
+}
+
+func (o *prsOutput) print(prs []SearchPR) error {
+	for _, pr := range prs {
+		if o.printed == 0 {
+			if err := o.format.header(); err != nil {
+				return err
+			}
+		}
+		if err := o.format.row(pr); err != nil {
+			return err
+		}
+		o.printed++
+	}
+
+	return o.format.flush()
+}
+
+// footer prints the buffered PRs, if any, and the footer of the format.
+func (o *prsOutput) footer() error {
+	if o.progress {
+		// clear the progress line
+		fmt.Fprint(os.Stderr, "\r\x1b[K")
+	}
+	if o.buffer() {
+		if err := o.printBuffered(); err != nil {
+			return err
+		}
+	}
+
+	return o.format.footer(o.shown, o.count)
+}
+
+// printBuffered prints the buffered PRs sorted, and grouped by formats that
+// support groups, with the groups in their own tables.
+func (o *prsOutput) printBuffered() error {
+	sortPRs(o.buffered, o.sort)
+
+	grouper, ok := o.format.(prsGrouper)
+	if o.groupBy == "" || !ok {
+		return o.print(o.buffered)
+	}
+
+	for _, g := range groupPRs(o.buffered, o.groupBy) {
+		if err := grouper.group(g.name, len(g.prs)); err != nil {
+			return err
+		}
+		for _, pr := range g.prs {
+			if err := o.format.row(pr); err != nil {
+				return err
+			}
+		}
+		if err := o.format.flush(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// prsJSON prints the PRs as a JSON array, once all of them are fetched.
+type prsJSON struct {
+	prs []PROutput
+}
+
+func (*prsJSON) header() error { return nil }
+func (*prsJSON) flush() error  { return nil }
+
+func (f *prsJSON) row(pr SearchPR) error {
+	f.prs = append(f.prs, newPROutput(pr))
+	return nil
+}
+
+func (f *prsJSON) footer(shown, count int) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(f.prs)
+}
+
+// prsNDJSON prints the PRs as JSON objects, one per line.
+type prsNDJSON struct {
+	enc *json.Encoder
+}
+
+func (prsNDJSON) header() error                 { return nil }
+func (prsNDJSON) flush() error                  { return nil }
+func (prsNDJSON) footer(shown, count int) error { return nil }
+
+func (f prsNDJSON) row(pr SearchPR) error {
+	return f.enc.Encode(newPROutput(pr))
+}
+
+// prsCSV prints the PRs as CSV with a header, lists (e.g. reviewers) are
+// separated by `;` and labels don't include their color.
+type prsCSV struct {
+	w *csv.Writer
+}
+
+func (prsCSV) footer(shown, count int) error { return nil }
+
+func (f prsCSV) header() error {
+	return f.w.Write([]string{
+		"number", "url", "repo", "title", "author", "branch", "base",
+		"status", "reviewers", "labels", "updatedAt", "createdAt",
+	})
+}
+
+func (f prsCSV) row(pr SearchPR) error {
+	o := newPROutput(pr)
+
+	labels := make([]string, len(o.Labels))
+	for i, l := range o.Labels {
+		labels[i] = l.Name
+	}
+
+	return f.w.Write([]string{
+		strconv.Itoa(o.Number),
+		o.URL,
+		o.Repo,
+		o.Title,
+		o.Author,
+		o.Branch,
+		o.Base,
+		o.Status,
+		strings.Join(o.Reviewers, ";"),
+		strings.Join(labels, ";"),
+		o.UpdatedAt.Format(time.RFC3339),
+		o.CreatedAt.Format(time.RFC3339),
+	})
+}
+
+func (f prsCSV) flush() error {
+	f.w.Flush()
+	return f.w.Error()
+}
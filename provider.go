@@ -0,0 +1,107 @@
+package castor
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Provider is a code host castor can list and review PRs from (e.g. GitHub).
+type Provider interface {
+	// SearchPRs lists the PRs matching the filters in conf, calling page
+	// with each page of results as they are fetched. It stops on the first
+	// error returned by page or once conf.Limit PRs are listed.
+	SearchPRs(conf Conf, page func(PRsSearch) error) error
+	// PRRefs returns the base and head branch names of a PR.
+	PRRefs(n int) (string, string, error)
+	// PR fetches the details of a PR.
+	PR(n int) (SearchPR, error)
+	// HeadRef returns the git ref the head of a PR can be fetched from,
+	// which also exists for PRs from forks.
+	HeadRef(n int) string
+}
+
+// rateLimiter is implemented by the providers that can report the status of
+// their API rate limit.
+type rateLimiter interface {
+	RateLimit() (RateLimit, error)
+}
+
+// updateLister is implemented by the providers that can list the PRs of a
+// search updated since a time with a single request, which tells if the
+// cached PRs of the search are up to date.
+type updateLister interface {
+	// updatedSince lists the PRs of the search in conf, in any state,
+	// updated at or after t. It returns false when they don't fit in a
+	// single page.
+	updatedSince(conf Conf, t time.Time) ([]SearchPR, bool, error)
+}
+
+// repository identifies the repo a git remote points to.
+type repository struct {
+	host  string
+	owner string
+	name  string
+	// path is the full path of the repo in the host, which could have
+	// more than two parts (e.g. GitLab subgroups).
+	path string
+	// provider is the provider detected from the remote URL, if any.
+	provider string
+}
+
+type providerFactory func(repo repository, conf Conf) Provider
+
+// providers maps the names accepted by `git config castor.provider` to the
+// factories of the Provider implementations.
+var providers = map[string]providerFactory{
+	"github":    newGitHub,
+	"gitlab":    newGitLab,
+	"gitea":     newGitea,
+	"bitbucket": newBitbucket,
+}
+
+// newProvider returns the Provider of the current repo. It's a variable to
+// allow swapping in a fake.
+var newProvider = providerFor
+
+// providerFor picks the Provider for the repo of conf.Remote, using the
+// `castor.provider` git config of the repo, then the provider configured
+// for the host of the remote and finally guessing it from the host name.
+func providerFor(conf Conf) (Provider, error) {
+	repo, err := remoteRepository(conf.Remote)
+	if err != nil {
+		return nil, err
+	}
+
+	kind := repoProvider()
+	if kind == "" {
+		kind = conf.Hosts[repo.host].Provider
+	}
+	if kind == "" {
+		kind = detectProvider(repo)
+	}
+
+	factory, ok := providers[kind]
+	if !ok {
+		return nil, fmt.Errorf("Unknown provider `%s`", kind)
+	}
+
+	return factory(repo, conf), nil
+}
+
+func detectProvider(repo repository) string {
+	host := repo.host
+
+	switch {
+	case repo.provider != "":
+		return repo.provider
+	case strings.Contains(host, "gitlab"):
+		return "gitlab"
+	case strings.Contains(host, "gitea"), strings.Contains(host, "forgejo"), host == "codeberg.org":
+		return "gitea"
+	case strings.Contains(host, "bitbucket") && host != "bitbucket.org":
+		return "bitbucket"
+	default:
+		return "github"
+	}
+}
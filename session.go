@@ -0,0 +1,515 @@
+package castor
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// session is a review started by `castor review`, it keeps what's needed to
+// go back to the branch it was started from.
+type session struct {
+	// Branch is the branch the review was started from.
+	Branch string `json:"branch"`
+	// Stash is the SHA of the stash commit holding the Work In Progress,
+	// which doesn't change when other stashes are pushed or dropped.
+	Stash     string    `json:"stash"`
+	PR        int       `json:"pr"`
+	StartedAt time.Time `json:"startedAt"`
+	// Clean tells if the tree was clean, so there's no stash to recover.
+	Clean bool `json:"clean,omitempty"`
+	// WIPFile tells if the .castorwip file was created to stash a clean
+	// tree, which older castor versions did.
+	WIPFile bool `json:"wipFile,omitempty"`
+	// Submodules holds the submodules that had changes, whose Work In
+	// Progress is stashed in the submodule itself.
+	Submodules []submoduleWIP `json:"submodules,omitempty"`
+}
+
+// id returns the short SHA of the stash of s, or `clean` if there's none.
+func (s session) id() string {
+	if s.Clean {
+		return "clean"
+	}
+	return fmt.Sprintf("%.7s", s.Stash)
+}
+
+// sessionsPath returns the path of the sessions store, which is shared by all
+// the worktrees of the repository like the stash is.
+func sessionsPath() (string, error) {
+	gitDir, err := git.Output("rev-parse", "--path-format=absolute", "--git-common-dir")
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(gitDir, "castor", "sessions.json"), nil
+}
+
+// loadSessions returns the saved sessions, oldest first.
+func loadSessions() ([]session, error) {
+	path, err := sessionsPath()
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var sessions []session
+	err = json.Unmarshal(b, &sessions)
+	return sessions, err
+}
+
+func saveSessions(sessions []session) error {
+	path, err := sessionsPath()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(sessions, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// write and rename to never leave a half written store behind
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func addSession(s session) error {
+	sessions, err := loadSessions()
+	if err != nil {
+		return err
+	}
+
+	return saveSessions(append(sessions, s))
+}
+
+func removeSession(rm session) error {
+	sessions, err := loadSessions()
+	if err != nil {
+		return err
+	}
+
+	kept := sessions[:0]
+	for _, s := range sessions {
+		if s.Stash != rm.Stash || !s.StartedAt.Equal(rm.StartedAt) {
+			kept = append(kept, s)
+		}
+	}
+
+	return saveSessions(kept)
+}
+
+// findSession returns the latest session started from branch, or the latest
+// one if branch is an empty string.
+func findSession(branch string) (session, bool, error) {
+	sessions, err := loadSessions()
+	if err != nil {
+		return session{}, false, err
+	}
+
+	for i := len(sessions) - 1; i >= 0; i-- {
+		if branch == "" || sessions[i].Branch == branch {
+			return sessions[i], true, nil
+		}
+	}
+
+	return session{}, false, nil
+}
+
+// stashRef returns the stash@{n} entry of the stash commit sha, if it's still
+// in the stash list.
+func stashRef(sha string) (string, bool) {
+	return stashRefIn(".", sha)
+}
+
+// stashRefIn is stashRef for the repository in dir, e.g. a submodule.
+func stashRefIn(dir, sha string) (string, bool) {
+	out, err := git.Output("-C", dir, "stash", "list", "--format=%H %gd")
+	if err != nil {
+		return "", false
+	}
+
+	for _, line := range strings.Split(out, "\n") {
+		parts := strings.Fields(line)
+		if len(parts) == 2 && parts[0] == sha {
+			return parts[1], true
+		}
+	}
+
+	return "", false
+}
+
+// restorePath returns the path where the session being recovered is kept
+// while its conflicts are resolved.
+func restorePath() (string, error) {
+	path, err := sessionsPath()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(filepath.Dir(path), "restore.json"), nil
+}
+
+func saveRestore(s session) error {
+	path, err := restorePath()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, b, 0644)
+}
+
+// loadRestore returns the session being recovered, if any.
+func loadRestore() (session, bool, error) {
+	path, err := restorePath()
+	if err != nil {
+		return session{}, false, err
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return session{}, false, nil
+	}
+	if err != nil {
+		return session{}, false, err
+	}
+
+	var s session
+	err = json.Unmarshal(b, &s)
+	return s, err == nil, err
+}
+
+func clearRestore() error {
+	path, err := restorePath()
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// goBackAll goes back to the branch the first session was started from.
+// The nested sessions that saved a clean tree are dropped, the ones with Work
+// In Progress are kept to go back to them later with `castor back --branch`.
+func goBackAll() error {
+	if !isRepo() {
+		return fmt.Errorf("Not a git repository")
+	}
+
+	if _, ok, _ := loadRestore(); ok {
+		return fmt.Errorf("Recovering a Work In Progress is in progress, run `castor back --continue` or `castor back --abort`")
+	}
+
+	sessions, err := loadSessions()
+	if err != nil {
+		return err
+	}
+	if len(sessions) == 0 {
+		return fmt.Errorf("Castor didn't save any Work In Progress in this repository")
+	}
+
+	for i := len(sessions) - 1; i > 0; i-- {
+		s := sessions[i]
+		if (!s.Clean && !s.WIPFile) || len(s.Submodules) > 0 {
+			fmt.Printf("Keeping the Work In Progress of branch `%s`, go back to it with `castor back --branch %s`\n\n", s.Branch, s.Branch)
+			continue
+		}
+
+		if ref, ok := stashRef(s.Stash); ok && s.WIPFile {
+			if err := git.Run("stash", "drop", ref); err != nil {
+				return err
+			}
+		}
+		if err := removeSession(s); err != nil {
+			return err
+		}
+	}
+
+	cur, err := currentBranch()
+	if err != nil {
+		return err
+	}
+
+	return restoreSession(cur, sessions[0])
+}
+
+// castorStashes lists the stash entries saved by castor, newest first.
+func castorStashes() ([]stashEntry, error) {
+	out, err := git.Output("stash", "list", "--format=%gd%x1f%H%x1f%gs%x1f%ct")
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []stashEntry
+	for _, line := range strings.Split(out, "\n") {
+		parts := strings.Split(line, "\x1f")
+		if len(parts) != 4 || !strings.Contains(parts[2], castorWIPMsg) {
+			continue
+		}
+
+		// the subject is `On [branch]: [msg]`, branch names can't have colons
+		subject := strings.SplitN(parts[2], ":", 2)
+		secs, _ := strconv.ParseInt(parts[3], 10, 64)
+
+		entries = append(entries, stashEntry{
+			id:      parts[0],
+			branch:  strings.TrimPrefix(subject[0], "On "),
+			msg:     strings.TrimSpace(subject[len(subject)-1]),
+			sha:     parts[1],
+			savedAt: time.Unix(secs, 0),
+		})
+	}
+
+	return entries, nil
+}
+
+// stashHasWIPFile tells if the stash commit sha saved the .castorwip file at
+// the top of the repo, among its untracked files.
+func stashHasWIPFile(sha string) bool {
+	out, err := git.Output("ls-tree", "--full-tree", "--name-only", sha+"^3", "--", castorWIPFile)
+	return err == nil && out != ""
+}
+
+// stashChanges returns the amount of tracked files changed and untracked
+// files saved in a stash entry, not counting the .castorwip file.
+func stashChanges(sha string) (int, int) {
+	files, _ := git.Output("stash", "show", "--name-only", sha)
+	untracked, _ := git.Output("ls-tree", "-r", "--name-only", sha+"^3")
+
+	count := func(out string) int {
+		n := 0
+		for _, f := range strings.Fields(out) {
+			if f != castorWIPFile {
+				n++
+			}
+		}
+		return n
+	}
+
+	return count(files), count(untracked)
+}
+
+// printSessions prints the reviews castor saved, oldest first so nested
+// reviews read as a chain, followed by the Work In Progress saved by older
+// castor versions:
+//
+//	ID       REVIEW                  AGE  FILES  UNTRACKED
+//	4f1c2a9  master -> PR #12        2h   3      1
+//	clean    castor/pr-12 -> PR #15  5m   0      0
+func printSessions() error {
+	if !isRepo() {
+		return fmt.Errorf("Not a git repository")
+	}
+
+	sessions, err := loadSessions()
+	if err != nil {
+		return err
+	}
+	entries, err := castorStashes()
+	if err != nil {
+		return err
+	}
+
+	listed := map[string]bool{}
+	for _, s := range sessions {
+		listed[s.Stash] = true
+	}
+	var legacy []stashEntry
+	for i := len(entries) - 1; i >= 0; i-- {
+		if !listed[entries[i].sha] {
+			legacy = append(legacy, entries[i])
+		}
+	}
+
+	if len(sessions) == 0 && len(legacy) == 0 {
+		fmt.Println("Castor didn't save any Work In Progress in this repository")
+		return nil
+	}
+
+	w := new(tabwriter.Writer)
+	w.Init(os.Stdout, 5, 2, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tREVIEW\tAGE\tFILES\tUNTRACKED")
+
+	for _, s := range sessions {
+		var files, untracked int
+		if !s.Clean {
+			files, untracked = stashChanges(s.Stash)
+		}
+		review := fmt.Sprintf("%s -> PR #%d", s.Branch, s.PR)
+		if len(s.Submodules) > 0 {
+			review += fmt.Sprintf(" (and %d submodules)", len(s.Submodules))
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\n", s.id(), review, since(s.StartedAt), files, untracked)
+	}
+	for _, e := range legacy {
+		files, untracked := stashChanges(e.sha)
+
+		fmt.Fprintf(w, "%.7s\t%s -> ?\t%s\t%d\t%d\n", e.sha, e.branch, since(e.savedAt), files, untracked)
+	}
+
+	return w.Flush()
+}
+
+// findSaved finds the session identified by arg, either the number of the
+// reviewed PR or a prefix of the SHA of its stash, including the Work In
+// Progress saved by older castor versions.
+func findSaved(arg string) (session, error) {
+	sessions, err := loadSessions()
+	if err != nil {
+		return session{}, err
+	}
+
+	if pr, err := strconv.Atoi(strings.TrimPrefix(arg, "#")); err == nil {
+		for i := len(sessions) - 1; i >= 0; i-- {
+			if sessions[i].PR == pr {
+				return sessions[i], nil
+			}
+		}
+	}
+
+	if len(arg) >= 4 {
+		for _, s := range sessions {
+			if !s.Clean && strings.HasPrefix(s.Stash, arg) {
+				return s, nil
+			}
+		}
+
+		entries, err := castorStashes()
+		if err != nil {
+			return session{}, err
+		}
+		for _, e := range entries {
+			if strings.HasPrefix(e.sha, arg) {
+				return session{Branch: e.branch, Stash: e.sha, WIPFile: stashHasWIPFile(e.sha)}, nil
+			}
+		}
+	}
+
+	return session{}, fmt.Errorf("Castor didn't save any Work In Progress for `%s`", arg)
+}
+
+// showSession prints the diff of the Work In Progress saved in a session,
+// including the untracked files.
+func showSession(arg string) error {
+	if !isRepo() {
+		return fmt.Errorf("Not a git repository")
+	}
+
+	s, err := findSaved(arg)
+	if err != nil {
+		return err
+	}
+
+	if s.Clean && len(s.Submodules) == 0 {
+		fmt.Printf("Branch `%s` was clean, there's no Work In Progress saved\n", s.Branch)
+		return nil
+	}
+
+	if !s.Clean {
+		if err := git.RunWithPipe("stash", "show", "-p", "--include-untracked", s.Stash); err != nil {
+			return err
+		}
+	}
+
+	return showSubmodules(s.Submodules)
+}
+
+// dropSession drops a session and its stash, asking for confirmation unless
+// yes is true.
+func dropSession(arg string, yes bool) error {
+	if !isRepo() {
+		return fmt.Errorf("Not a git repository")
+	}
+
+	s, err := findSaved(arg)
+	if err != nil {
+		return err
+	}
+
+	if !yes && (!s.Clean || len(s.Submodules) > 0) {
+		var files, untracked int
+		if !s.Clean {
+			files, untracked = stashChanges(s.Stash)
+		}
+		question := fmt.Sprintf(
+			"Drop the Work In Progress of branch `%s` (%d files changed, %d untracked, %d submodules)? It can't be undone",
+			s.Branch,
+			files,
+			untracked,
+			len(s.Submodules),
+		)
+		if !confirm(question) {
+			return nil
+		}
+	}
+
+	if ref, ok := stashRef(s.Stash); ok && !s.Clean {
+		if err := git.RunWithPipe("stash", "drop", ref); err != nil {
+			return err
+		}
+	}
+	if err := dropSubmodules(s.Submodules); err != nil {
+		return err
+	}
+
+	return removeSession(s)
+}
+
+// confirm asks a yes/no question, defaulting to no.
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+
+	return answer == "y" || answer == "yes"
+}
+
+// since returns a rough duration since t (e.g. 5m, 2h or 3d).
+func since(t time.Time) string {
+	d := time.Since(t)
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%dd", int(d.Hours()/24))
+	}
+}
@@ -0,0 +1,116 @@
+package castor
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// prsSorts maps the orders `castor prs --sort` accepts to whether PR a goes
+// before b. Dates and numbers sort newest first, names alphabetically.
+var prsSorts = map[string]func(a, b SearchPR) bool{
+	"updated": func(a, b SearchPR) bool {
+		return a.UpdatedAt.After(b.UpdatedAt)
+	},
+	"created": func(a, b SearchPR) bool {
+		return a.CreatedAt.After(b.CreatedAt)
+	},
+	"number": func(a, b SearchPR) bool {
+		return a.Number > b.Number
+	},
+	"repo": func(a, b SearchPR) bool {
+		return prsColumns["repo"](a) < prsColumns["repo"](b)
+	},
+	"author": func(a, b SearchPR) bool {
+		return strings.ToLower(a.Author.Login) < strings.ToLower(b.Author.Login)
+	},
+}
+
+// prsGroups maps the groups `castor prs --group-by` accepts to the groups a
+// PR is in, a PR with many labels is in the group of each of them.
+var prsGroups = map[string]func(pr SearchPR) []string{
+	"repo": func(pr SearchPR) []string {
+		return []string{prsColumns["repo"](pr)}
+	},
+	"status": func(pr SearchPR) []string {
+		return []string{prStatus(pr)}
+	},
+	"label": func(pr SearchPR) []string {
+		if len(pr.Labels.Nodes) == 0 {
+			return []string{noLabel}
+		}
+		names := make([]string, len(pr.Labels.Nodes))
+		for i, l := range pr.Labels.Nodes {
+			names[i] = l.Name
+		}
+		return names
+	},
+}
+
+// noLabel is the group of the PRs without labels, listed last.
+const noLabel = "No label"
+
+// statusOrder is the order of the groups by status.
+var statusOrder = map[string]int{"Open": 0, "Closed": 1, "Merged": 2}
+
+// prsGroup is a group of PRs, in the order they are listed.
+type prsGroup struct {
+	name string
+	prs  []SearchPR
+}
+
+// sortPRs sorts prs in the order by, keeping the order of the equal ones.
+func sortPRs(prs []SearchPR, by string) {
+	less, ok := prsSorts[by]
+	if !ok {
+		return
+	}
+
+	sort.SliceStable(prs, func(i, j int) bool {
+		return less(prs[i], prs[j])
+	})
+}
+
+// groupPRs groups prs by the field by, the groups of statuses are in the
+// Open, Closed and Merged order and the other ones alphabetically.
+func groupPRs(prs []SearchPR, by string) []prsGroup {
+	var groups []prsGroup
+	index := map[string]int{}
+
+	for _, pr := range prs {
+		for _, name := range prsGroups[by](pr) {
+			i, ok := index[name]
+			if !ok {
+				i = len(groups)
+				index[name] = i
+				groups = append(groups, prsGroup{name: name})
+			}
+			groups[i].prs = append(groups[i].prs, pr)
+		}
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		a, b := groups[i].name, groups[j].name
+		switch {
+		case by == "status":
+			return statusOrder[a] < statusOrder[b]
+		case a == noLabel || b == noLabel:
+			return b == noLabel && a != noLabel
+		default:
+			return strings.ToLower(a) < strings.ToLower(b)
+		}
+	})
+
+	return groups
+}
+
+// checkSort returns an error if the sort or the group of conf are unknown.
+func checkSort(conf Conf) error {
+	if _, ok := prsSorts[conf.Sort]; conf.Sort != "" && !ok {
+		return fmt.Errorf("Unknown sort `%s`, use `updated`, `created`, `number`, `repo` or `author`", conf.Sort)
+	}
+	if _, ok := prsGroups[conf.GroupBy]; conf.GroupBy != "" && !ok {
+		return fmt.Errorf("Unknown group `%s`, use `repo`, `status` or `label`", conf.GroupBy)
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package castor
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// RepoStatus is the status of the working tree, as reported by
+// `git status --porcelain=v2 --branch`.
+type RepoStatus struct {
+	// Branch is the current branch, `(detached)` when HEAD is detached.
+	Branch string
+	// Head is the SHA of the current commit, `(initial)` in a new repo.
+	Head     string
+	Upstream string
+	Entries  []StatusEntry
+	// Detached tells if HEAD is detached, i.e. not in a branch.
+	Detached bool
+	// Operation is the operation in progress (e.g. merge or rebase), if any.
+	Operation string
+	// Sparse tells if the repo uses a sparse checkout.
+	Sparse bool
+}
+
+// StatusEntry is a changed, unmerged, untracked or ignored path.
+type StatusEntry struct {
+	// Kind is `1` for changed, `2` for renamed or copied, `u` for unmerged,
+	// `?` for untracked and `!` for ignored paths.
+	Kind byte
+	// XY are the index and working tree status (e.g. `M.` for staged changes).
+	XY string
+	// Sub is `N...` for files, and `S<c><m><u>` for submodules, telling if
+	// their commit changed, they have changes or untracked files (e.g. `S.M.`).
+	Sub      string
+	Path     string
+	OrigPath string
+}
+
+// Clean tells if there is nothing to commit, nor untracked files.
+func (s RepoStatus) Clean() bool {
+	for _, e := range s.Entries {
+		if e.Kind != '!' {
+			return false
+		}
+	}
+	return true
+}
+
+// current returns the current branch, or the SHA of the current commit if
+// HEAD is detached.
+func (s RepoStatus) current() string {
+	if s.Detached {
+		return s.Head
+	}
+	return s.Branch
+}
+
+// submodule tells if e is a submodule.
+func (e StatusEntry) submodule() bool {
+	return strings.HasPrefix(e.Sub, "S")
+}
+
+// parseStatus parses the output of `git status --porcelain=v2 --branch -z`,
+// its records are NUL terminated so paths can have any character:
+//
+//	# branch.oid <commit>
+//	# branch.head <branch>
+//	1 <XY> <sub> <mH> <mI> <mW> <hH> <hI> <path>
+//	2 <XY> <sub> <mH> <mI> <mW> <hH> <hI> <X><score> <path>NUL<origPath>
+//	u <XY> <sub> <m1> <m2> <m3> <mW> <h1> <h2> <h3> <path>
+//	? <path>
+func parseStatus(out string) RepoStatus {
+	var status RepoStatus
+
+	records := strings.Split(out, "\x00")
+	for i := 0; i < len(records); i++ {
+		r := records[i]
+		if r == "" {
+			continue
+		}
+
+		switch r[0] {
+		case '#':
+			header := strings.SplitN(r, " ", 3)
+			if len(header) != 3 {
+				continue
+			}
+			switch header[1] {
+			case "branch.oid":
+				status.Head = header[2]
+			case "branch.head":
+				status.Branch = header[2]
+			case "branch.upstream":
+				status.Upstream = header[2]
+			}
+		case '1':
+			if f := strings.SplitN(r, " ", 9); len(f) == 9 {
+				status.Entries = append(status.Entries, StatusEntry{Kind: '1', XY: f[1], Sub: f[2], Path: f[8]})
+			}
+		case '2':
+			if f := strings.SplitN(r, " ", 10); len(f) == 10 {
+				e := StatusEntry{Kind: '2', XY: f[1], Sub: f[2], Path: f[9]}
+				if i+1 < len(records) {
+					i++
+					e.OrigPath = records[i]
+				}
+				status.Entries = append(status.Entries, e)
+			}
+		case 'u':
+			if f := strings.SplitN(r, " ", 11); len(f) == 11 {
+				status.Entries = append(status.Entries, StatusEntry{Kind: 'u', XY: f[1], Sub: f[2], Path: f[10]})
+			}
+		case '?', '!':
+			if len(r) > 2 {
+				status.Entries = append(status.Entries, StatusEntry{Kind: r[0], Path: r[2:]})
+			}
+		}
+	}
+
+	return status
+}
+
+// operations maps the files git keeps under .git while an operation is in
+// progress to the operation and how to finish it. am also uses rebase-apply,
+// so its `applying` file is checked first.
+var operations = []struct {
+	path string
+	name string
+	hint string
+}{
+	{"rebase-merge", "rebase", "Finish it with `git rebase --continue` or cancel it with `git rebase --abort` first"},
+	{"rebase-apply/applying", "am", "Finish it with `git am --continue` or cancel it with `git am --abort` first"},
+	{"rebase-apply", "rebase", "Finish it with `git rebase --continue` or cancel it with `git rebase --abort` first"},
+	{"MERGE_HEAD", "merge", "Finish it with `git merge --continue` or cancel it with `git merge --abort` first"},
+	{"CHERRY_PICK_HEAD", "cherry-pick", "Finish it with `git cherry-pick --continue` or cancel it with `git cherry-pick --abort` first"},
+	{"REVERT_HEAD", "revert", "Finish it with `git revert --continue` or cancel it with `git revert --abort` first"},
+	{"BISECT_LOG", "bisect", "Finish it with `git bisect reset` first"},
+}
+
+// detectState fills the state of the repo that porcelain status doesn't
+// report, reading it from the git dir and config.
+func detectState(g GitRunner, status *RepoStatus) {
+	status.Detached = status.Branch == "(detached)"
+	status.Operation = repoOperation(g)
+
+	sparse, _ := g.Output("config", "--bool", "core.sparseCheckout")
+	status.Sparse = sparse == "true"
+}
+
+func repoOperation(g GitRunner) string {
+	for _, op := range operations {
+		path, err := g.Output("rev-parse", "--git-path", op.path)
+		if err != nil {
+			continue
+		}
+		if _, err := os.Stat(path); err == nil {
+			return op.name
+		}
+	}
+	return ""
+}
+
+// checkSafe returns an error explaining why castor can't switch branches in
+// the state of the repo, if it can't.
+func checkSafe(status RepoStatus) error {
+	if status.Operation == "" {
+		return nil
+	}
+
+	for _, op := range operations {
+		if op.name == status.Operation {
+			return fmt.Errorf("A %s is in progress, switching branches now could break it.\n%s", op.name, op.hint)
+		}
+	}
+	return fmt.Errorf("A %s is in progress, finish it first", status.Operation)
+}
@@ -0,0 +1,96 @@
+package castor
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		// out is the output of `git status --porcelain=v2 --branch -z
+		// --untracked-files=all`
+		out  string
+		want RepoStatus
+	}{
+		{
+			name: "changes, submodule and rename",
+			out: "# branch.oid 4a3a32b7bda8646c0b23c0af92816080e94bb637\x00" +
+				"# branch.head main\x00" +
+				"1 M. N... 100644 100644 100644 78981922613b2afb6025042ff6bd878ac1994e85 93829c7b4af9dfbeea3b31395b042a614d7a190d a.txt\x00" +
+				"1 .M N... 100644 100644 100644 f2ad6c76f0115a6ba5b00456a849810e7ec0af20 f2ad6c76f0115a6ba5b00456a849810e7ec0af20 c.txt\x00" +
+				"1 .M S.M. 160000 160000 160000 b9acca2a6c9f3ab6379af22e2dc4a924ee59d6ae b9acca2a6c9f3ab6379af22e2dc4a924ee59d6ae lib/sub\x00" +
+				"2 R. N... 100644 100644 100644 61780798228d17af2d34fce4cfbdf35556832472 61780798228d17af2d34fce4cfbdf35556832472 R100 new name.txt\x00old name.txt\x00" +
+				"? un tracked.txt\x00",
+			want: RepoStatus{
+				Branch: "main",
+				Head:   "4a3a32b7bda8646c0b23c0af92816080e94bb637",
+				Entries: []StatusEntry{
+					{Kind: '1', XY: "M.", Sub: "N...", Path: "a.txt"},
+					{Kind: '1', XY: ".M", Sub: "N...", Path: "c.txt"},
+					{Kind: '1', XY: ".M", Sub: "S.M.", Path: "lib/sub"},
+					{Kind: '2', XY: "R.", Sub: "N...", Path: "new name.txt", OrigPath: "old name.txt"},
+					{Kind: '?', Path: "un tracked.txt"},
+				},
+			},
+		},
+		{
+			name: "unmerged",
+			out: "# branch.oid f855967b5da3ce4b79b1a274060b6a5abb7f0bf0\x00" +
+				"# branch.head main\x00" +
+				"u UU N... 100644 100644 100644 100644 f2ad6c76f0115a6ba5b00456a849810e7ec0af20 ba2906d0666cf726c7eaadd2cd3db615dedfdf3a e45c9c2666d44e0327c1f9c239a74c508336053e c.txt\x00",
+			want: RepoStatus{
+				Branch:  "main",
+				Head:    "f855967b5da3ce4b79b1a274060b6a5abb7f0bf0",
+				Entries: []StatusEntry{{Kind: 'u', XY: "UU", Sub: "N...", Path: "c.txt"}},
+			},
+		},
+		{
+			name: "upstream",
+			out: "# branch.oid b9acca2a6c9f3ab6379af22e2dc4a924ee59d6ae\x00" +
+				"# branch.head main\x00" +
+				"# branch.upstream origin/main\x00" +
+				"# branch.ab +0 -0\x00" +
+				"? a b.txt\x00",
+			want: RepoStatus{
+				Branch:   "main",
+				Head:     "b9acca2a6c9f3ab6379af22e2dc4a924ee59d6ae",
+				Upstream: "origin/main",
+				Entries:  []StatusEntry{{Kind: '?', Path: "a b.txt"}},
+			},
+		},
+		{
+			name: "detached",
+			out: "# branch.oid 4a3a32b7bda8646c0b23c0af92816080e94bb637\x00" +
+				"# branch.head (detached)\x00",
+			want: RepoStatus{Branch: "(detached)", Head: "4a3a32b7bda8646c0b23c0af92816080e94bb637"},
+		},
+		{
+			name: "new repo",
+			out:  "# branch.oid (initial)\x00# branch.head main\x00",
+			want: RepoStatus{Branch: "main", Head: "(initial)"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseStatus(tt.out); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectState(t *testing.T) {
+	g := fakeGit{outputs: map[string]string{"config --bool core.sparseCheckout": "true"}}
+
+	status := parseStatus("# branch.oid 4a3a32b7bda8646c0b23c0af92816080e94bb637\x00# branch.head (detached)\x00")
+	detectState(g, &status)
+
+	if !status.Detached || !status.Sparse || status.Operation != "" {
+		t.Errorf("got %+v, want a detached sparse checkout", status)
+	}
+	if got := status.current(); got != status.Head {
+		t.Errorf("got current %s, want %s", got, status.Head)
+	}
+}
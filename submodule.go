@@ -0,0 +1,174 @@
+package castor
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// submoduleWIP is the Work In Progress of a submodule. Stashing in the
+// superproject only saves the commit a submodule is at, so its changes are
+// stashed in the submodule itself.
+type submoduleWIP struct {
+	// Path is the path of the submodule from the top of the superproject.
+	Path string `json:"path"`
+	// Head is the branch the submodule was in, or its commit if detached.
+	Head string `json:"head"`
+	// Stash is the SHA of the stash commit in the submodule, empty when it
+	// only had its commit changed.
+	Stash string `json:"stash,omitempty"`
+}
+
+// hasSubmodules tells if the repository has initialized submodules, the ones
+// `git submodule status` doesn't prefix with `-`.
+func hasSubmodules() bool {
+	out, err := git.Output("submodule", "status")
+	if err != nil {
+		return false
+	}
+
+	for _, line := range strings.Split(out, "\n") {
+		if line != "" && !strings.HasPrefix(line, "-") {
+			return true
+		}
+	}
+	return false
+}
+
+// updateSubmodules checks out the commits of the initialized submodules
+// recorded in the current branch. The ones the user didn't initialize are
+// left alone, as `git checkout --recurse-submodules` does.
+func updateSubmodules() error {
+	if !hasSubmodules() {
+		return nil
+	}
+
+	fmt.Printf("\nUpdating submodules\n\n")
+	return git.RunWithPipe("submodule", "update", "--recursive")
+}
+
+// saveSubmodules stashes the changes of the submodules in status and records
+// the commit they are at. Only the submodules of the repository are saved,
+// not the ones nested in them.
+func saveSubmodules(status RepoStatus) ([]submoduleWIP, error) {
+	top, err := git.Output("rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+
+	var subs []submoduleWIP
+	for _, e := range status.Entries {
+		if !e.submodule() || e.Sub == "S..." {
+			continue
+		}
+		dir := filepath.Join(top, e.Path)
+
+		head, err := git.Output("-C", dir, "symbolic-ref", "--quiet", "--short", "HEAD")
+		if err != nil {
+			head, err = git.Output("-C", dir, "rev-parse", "HEAD")
+			if err != nil {
+				return subs, err
+			}
+		}
+		sub := submoduleWIP{Path: e.Path, Head: head}
+
+		if e.Sub[2] == 'M' || e.Sub[3] == 'U' {
+			fmt.Printf("Saving Work In Progress of submodule `%s`\n\n", e.Path)
+			sub.Stash, err = saveStash(dir)
+			if err != nil {
+				fmt.Printf("\nCouldn't stash files...\n\n")
+				return subs, err
+			}
+		}
+
+		subs = append(subs, sub)
+	}
+
+	return subs, nil
+}
+
+// restoreSubmodules checks out the commits the submodules were at and recovers
+// their Work In Progress. A stash that doesn't apply is kept in the submodule.
+func restoreSubmodules(subs []submoduleWIP) error {
+	if len(subs) == 0 {
+		return nil
+	}
+
+	top, err := git.Output("rev-parse", "--show-toplevel")
+	if err != nil {
+		return err
+	}
+
+	for _, sub := range subs {
+		dir := filepath.Join(top, sub.Path)
+
+		fmt.Printf("\nRecovering submodule `%s`\n\n", sub.Path)
+		if err := git.RunWithPipe("-C", dir, "checkout", sub.Head); err != nil {
+			return err
+		}
+		if sub.Stash == "" {
+			continue
+		}
+
+		ref, ok := stashRefIn(dir, sub.Stash)
+		if !ok {
+			return fmt.Errorf(
+				"The Work In Progress of submodule `%s` is no longer in its stash, try recovering it with `git -C %s stash apply %s`",
+				sub.Path,
+				sub.Path,
+				sub.Stash,
+			)
+		}
+		if err := git.RunWithPipe("-C", dir, "stash", "apply", "--index", ref); err != nil {
+			return fmt.Errorf(
+				"Couldn't recover the Work In Progress of submodule `%s`, it's still saved in its stash as %s",
+				sub.Path,
+				ref,
+			)
+		}
+		if err := git.Run("-C", dir, "stash", "drop", ref); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// showSubmodules prints the diff of the Work In Progress of the submodules.
+func showSubmodules(subs []submoduleWIP) error {
+	top, err := git.Output("rev-parse", "--show-toplevel")
+	if err != nil {
+		return err
+	}
+
+	for _, sub := range subs {
+		if sub.Stash == "" {
+			continue
+		}
+		fmt.Printf("\nSubmodule `%s`:\n\n", sub.Path)
+		if err := git.RunWithPipe("-C", filepath.Join(top, sub.Path), "stash", "show", "-p", "--include-untracked", sub.Stash); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// dropSubmodules drops the stashes of the Work In Progress of the submodules.
+func dropSubmodules(subs []submoduleWIP) error {
+	top, err := git.Output("rev-parse", "--show-toplevel")
+	if err != nil {
+		return err
+	}
+
+	for _, sub := range subs {
+		dir := filepath.Join(top, sub.Path)
+		if ref, ok := stashRefIn(dir, sub.Stash); ok && sub.Stash != "" {
+			if err := git.RunWithPipe("-C", dir, "stash", "drop", ref); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
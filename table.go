@@ -0,0 +1,58 @@
+package castor
+
+import (
+	"io"
+	"strings"
+)
+
+// table aligns rows of cells in columns separated by `|`, measuring the cells
+// by the columns they take in the terminal so they can be colored or have
+// wide characters (text/tabwriter counts the bytes of the escapes and runes).
+type table struct {
+	w    io.Writer
+	rows [][]string
+	// minWidth is the min width of a column, padding the spaces after a cell.
+	minWidth int
+	padding  int
+}
+
+func newTable(w io.Writer) *table {
+	return &table{w: w, minWidth: 5, padding: 1}
+}
+
+// add adds a row, its last cell isn't aligned so it can be of any width.
+func (t *table) add(cells ...string) {
+	t.rows = append(t.rows, cells)
+}
+
+// flush prints the rows added since the last flush, aligning only them.
+func (t *table) flush() error {
+	var widths []int
+	for _, row := range t.rows {
+		for i, cell := range row[:len(row)-1] {
+			if i == len(widths) {
+				widths = append(widths, t.minWidth)
+			}
+			if w := width(cell) + t.padding; w > widths[i] {
+				widths[i] = w
+			}
+		}
+	}
+
+	var b strings.Builder
+	for _, row := range t.rows {
+		for i, cell := range row {
+			b.WriteString(cell)
+			if i == len(row)-1 {
+				break
+			}
+			b.WriteString(strings.Repeat(" ", widths[i]-width(cell)))
+			b.WriteString("|")
+		}
+		b.WriteString("\n")
+	}
+	t.rows = nil
+
+	_, err := io.WriteString(t.w, b.String())
+	return err
+}
@@ -0,0 +1,97 @@
+package castor
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// colors maps the names accepted by the `color` template func to their
+// attributes.
+var colors = map[string]color.Attribute{
+	"black":   color.FgBlack,
+	"red":     color.FgRed,
+	"green":   color.FgGreen,
+	"yellow":  color.FgYellow,
+	"blue":    color.FgBlue,
+	"magenta": color.FgMagenta,
+	"cyan":    color.FgCyan,
+	"white":   color.FgWhite,
+	"gray":    color.FgHiBlack,
+	"bold":    color.Bold,
+}
+
+// templateFuncs are the helpers available to `castor prs --format` templates.
+var templateFuncs = template.FuncMap{
+	// {{.Title | truncate 20}}
+	"truncate": func(n int, s string) string {
+		return truncate(s, n)
+	},
+	// {{color "green" .Status}}
+	"color": func(name, s string) (string, error) {
+		attr, ok := colors[name]
+		if !ok {
+			return "", fmt.Errorf("unknown color %q", name)
+		}
+		return color.New(attr).Sprint(s), nil
+	},
+	// {{hex "#ff8800" .Author}}
+	"hex": func(hex, s string) string {
+		r, g, b := hex2rgb(hex)
+		return fgString(s, r, g, b)
+	},
+	// {{ago .UpdatedAt}}
+	"ago": func(t time.Time) string {
+		return since(t) + " ago"
+	},
+	// {{join ", " .Reviewers}}
+	"join": func(sep string, l []string) string {
+		return strings.Join(l, sep)
+	},
+	// {{reviewers .Reviewers}}
+	"reviewers": func(l []string) string {
+		return strings.Join(l, ", ")
+	},
+	// {{labels .Labels}}
+	"labels": func(ls []Label) string {
+		return labels(Labels{TotalCount: len(ls), Nodes: ls})
+	},
+}
+
+// prsTemplate prints each PR with a text/template, executed with the
+// PROutput of the PR and followed by a newline.
+type prsTemplate struct {
+	tmpl *template.Template
+}
+
+// newPRsTemplate parses format, the name of a template in conf.Templates or
+// the template itself.
+func newPRsTemplate(format string, conf Conf) (*prsTemplate, error) {
+	text, ok := conf.Templates[format]
+	if !ok {
+		text = format
+	}
+
+	tmpl, err := template.New("format").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid --format template: %s", err)
+	}
+
+	return &prsTemplate{tmpl}, nil
+}
+
+func (prsTemplate) header() error                 { return nil }
+func (prsTemplate) flush() error                  { return nil }
+func (prsTemplate) footer(shown, count int) error { return nil }
+
+func (t prsTemplate) row(pr SearchPR) error {
+	if err := t.tmpl.Execute(os.Stdout, newPROutput(pr)); err != nil {
+		return err
+	}
+	_, err := fmt.Println()
+	return err
+}
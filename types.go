@@ -47,21 +47,30 @@ type WithLogin struct {
 
 type PRsSearch struct {
 	IssueCount int        `json:"issueCount"`
+	PageInfo   PageInfo   `json:"pageInfo"`
 	Nodes      []SearchPR `json:"nodes"`
 }
 
+type PageInfo struct {
+	HasNextPage bool   `json:"hasNextPage"`
+	EndCursor   string `json:"endCursor"`
+}
+
 type SearchPR struct {
 	URL                 string         `json:"url"`
 	Number              int            `json:"number"`
 	Title               string         `json:"title"`
 	Author              WithLogin      `json:"author"`
 	HeadRefName         string         `json:"headRefName"`
+	BaseRefName         string         `json:"baseRefName"`
 	HeadRepository      Name           `json:"headRepository"`
 	HeadRepositoryOwner Login          `json:"headRepositoryOwner"`
 	Closed              bool           `json:"closed"`
 	Merged              bool           `json:"Merged"`
 	Labels              Labels         `json:"Labels"`
 	ReviewRequests      ReviewRequests `json:"reviewRequests"`
+	CreatedAt           time.Time      `json:"createdAt"`
+	UpdatedAt           time.Time      `json:"updatedAt"`
 }
 
 type Name struct {
@@ -86,6 +95,9 @@ type Review struct {
 
 type RequestedReviewer struct {
 	RequestedReviewer LoginAndName `json:"requestedReviewer"`
+	// State is the review the reviewer left without approving, as Bitbucket
+	// reports NEEDS_WORK, shown only in the reviews column.
+	State string `json:"state,omitempty"`
 }
 
 type ReviewRequests struct {
@@ -97,3 +109,11 @@ type Labels struct {
 	TotalCount int     `json:"totalCount"`
 	Nodes      []Label `json:"nodes"`
 }
+
+type RateLimit struct {
+	User      string    `json:"-"`
+	Limit     int       `json:"limit"`
+	Cost      int       `json:"cost"`
+	Remaining int       `json:"remaining"`
+	ResetAt   time.Time `json:"resetAt"`
+}
@@ -0,0 +1,126 @@
+package castor
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// ansiEscape matches the ANSI escape sequences terminals don't print, e.g. the
+// ones setting colors.
+var ansiEscape = regexp.MustCompile("\x1b\\[[0-9;?]*[ -/]*[@-~]")
+
+// wideRanges are the runes terminals print in two columns: the East Asian
+// wide and fullwidth ones and the emoji presented as such by default.
+var wideRanges = []struct{ lo, hi rune }{
+	{0x1100, 0x115F}, // Hangul Jamo
+	{0x231A, 0x231B},
+	{0x2329, 0x232A},
+	{0x23E9, 0x23EC},
+	{0x23F0, 0x23F0},
+	{0x23F3, 0x23F3},
+	{0x25FD, 0x25FE},
+	{0x2614, 0x2615},
+	{0x2648, 0x2653},
+	{0x267F, 0x267F},
+	{0x2693, 0x2693},
+	{0x26A1, 0x26A1},
+	{0x26AA, 0x26AB},
+	{0x26BD, 0x26BE},
+	{0x26C4, 0x26C5},
+	{0x26CE, 0x26CE},
+	{0x26D4, 0x26D4},
+	{0x26EA, 0x26EA},
+	{0x26F2, 0x26F3},
+	{0x26F5, 0x26F5},
+	{0x26FA, 0x26FA},
+	{0x26FD, 0x26FD},
+	{0x2705, 0x2705},
+	{0x270A, 0x270B},
+	{0x2728, 0x2728},
+	{0x274C, 0x274C},
+	{0x274E, 0x274E},
+	{0x2753, 0x2755},
+	{0x2757, 0x2757},
+	{0x2795, 0x2797},
+	{0x27B0, 0x27B0},
+	{0x27BF, 0x27BF},
+	{0x2B1B, 0x2B1C},
+	{0x2B50, 0x2B50},
+	{0x2B55, 0x2B55},
+	{0x2E80, 0x303E},   // CJK radicals and punctuation
+	{0x3041, 0x33FF},   // Kana, Bopomofo and CJK compatibility
+	{0x3400, 0x4DBF},   // CJK extension A
+	{0x4E00, 0x9FFF},   // CJK unified ideographs
+	{0xA000, 0xA4CF},   // Yi
+	{0xA960, 0xA97F},   // Hangul Jamo extended A
+	{0xAC00, 0xD7A3},   // Hangul syllables
+	{0xF900, 0xFAFF},   // CJK compatibility ideographs
+	{0xFE10, 0xFE19},   // vertical forms
+	{0xFE30, 0xFE6F},   // CJK compatibility and small forms
+	{0xFF00, 0xFF60},   // fullwidth forms
+	{0xFFE0, 0xFFE6},   // fullwidth signs
+	{0x16FE0, 0x16FE4}, // ideographic symbols
+	{0x17000, 0x18AFF}, // Tangut
+	{0x1B000, 0x1B2FF}, // Kana supplement and extended
+	{0x1F004, 0x1F004},
+	{0x1F0CF, 0x1F0CF},
+	{0x1F18E, 0x1F18E},
+	{0x1F191, 0x1F19A},
+	{0x1F200, 0x1F202},
+	{0x1F210, 0x1F23B},
+	{0x1F240, 0x1F248},
+	{0x1F250, 0x1F251},
+	{0x1F260, 0x1F265},
+	{0x1F300, 0x1F64F}, // pictographs and emoticons
+	{0x1F680, 0x1F6FF}, // transport and map symbols
+	{0x1F7E0, 0x1F7EB},
+	{0x1F90C, 0x1F9FF}, // supplemental pictographs
+	{0x1FA70, 0x1FAFF}, // pictographs extended A
+	{0x20000, 0x2FFFD}, // CJK extension B and later
+	{0x30000, 0x3FFFD},
+}
+
+// runeWidth returns the columns a terminal prints r in, 0 for the runes that
+// combine with the previous one (e.g. accents or the emoji joiner).
+func runeWidth(r rune) int {
+	switch {
+	case r < 0x20, r >= 0x7F && r < 0xA0:
+		return 0
+	case r < 0x300:
+		return 1
+	case unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf):
+		return 0
+	}
+
+	for _, w := range wideRanges {
+		if r < w.lo {
+			break
+		}
+		if r <= w.hi {
+			return 2
+		}
+	}
+	return 1
+}
+
+// zwj is the zero width joiner, the runes after it are printed in the same
+// glyph as the ones before, e.g. in the emoji of a family.
+const zwj = '\u200d'
+
+// width returns the columns a terminal prints s in, ignoring ANSI escapes.
+func width(s string) int {
+	if strings.ContainsRune(s, '\x1b') {
+		s = ansiEscape.ReplaceAllString(s, "")
+	}
+
+	n := 0
+	var prev rune
+	for _, r := range s {
+		if prev != zwj {
+			n += runeWidth(r)
+		}
+		prev = r
+	}
+	return n
+}
@@ -0,0 +1,147 @@
+package castor
+
+import (
+	"bytes"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestWidth(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want int
+	}{
+		{"empty", "", 0},
+		{"ascii", "castor", 6},
+		{"cjk", "日本", 4},
+		{"hangul", "한국어", 6},
+		{"fullwidth", "ＡＢ", 4},
+		{"mixed", "PR 日本", 7},
+		{"emoji", "🚀", 2},
+		{"emoji joined", "👨‍👩‍👧", 2},
+		{"combining accent", "e\u0301te\u0301", 4},
+		{"control", "a\tb", 2},
+		{"colored", "\x1b[38;2;255;200;0mMissing\x1b[0m", 7},
+		{"colored wide", "\x1b[1m日本\x1b[0m 🚀", 7},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := width(tt.s); got != tt.want {
+				t.Errorf("got width %d for %q, want %d", got, tt.s, tt.want)
+			}
+		})
+	}
+}
+
+func TestRuneWidth(t *testing.T) {
+	tests := []struct {
+		r    rune
+		want int
+	}{
+		{'a', 1},
+		{'é', 1},
+		{'\n', 0},
+		{'\u0301', 0},
+		{zwj, 0},
+		{'日', 2},
+		{'ア', 2},
+		{'Ａ', 2},
+		{'🚀', 2},
+	}
+
+	for _, tt := range tests {
+		if got := runeWidth(tt.r); got != tt.want {
+			t.Errorf("got width %d for %U, want %d", got, tt.r, tt.want)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		num  int
+		want string
+	}{
+		{"short", "castor", 10, "castor"},
+		{"exact", "castor", 6, "castor"},
+		{"ascii", "a long title", 8, "a lon..."},
+		{"wide", "日本語のタイトル", 9, "日本語..."},
+		// 語 would take the 6th column, past the 5 left for the text
+		{"wide not split", "日本語のタイトル", 8, "日本..."},
+		{"combining accent kept", "e\u0301e\u0301e\u0301e\u0301e\u0301", 4, "e\u0301..."},
+		{"emoji joined kept", "👨‍👩‍👧 family", 5, "👨‍👩‍👧..."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := truncate(tt.s, tt.num)
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("%q isn't valid UTF-8", got)
+			}
+			if width(got) > tt.num {
+				t.Errorf("%q takes %d columns, more than %d", got, width(got), tt.num)
+			}
+		})
+	}
+}
+
+func TestTableFlush(t *testing.T) {
+	tests := []struct {
+		name string
+		rows [][]string
+		want string
+	}{
+		{
+			name: "min width",
+			rows: [][]string{{"#1", "a", "x"}},
+			want: "#1   |a    |x\n",
+		},
+		{
+			name: "wide and colored",
+			rows: [][]string{
+				{"#1", "日本", "unaligned last cell"},
+				{"\x1b[1m#12\x1b[0m", "title", "y"},
+				{"#123", "🚀 go", "z"},
+			},
+			want: "#1   |日本  |unaligned last cell\n" +
+				"\x1b[1m#12\x1b[0m  |title |y\n" +
+				"#123 |🚀 go |z\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			tab := newTable(&buf)
+			for _, row := range tt.rows {
+				tab.add(row...)
+			}
+			if err := tab.flush(); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("flushed rows only", func(t *testing.T) {
+		var buf bytes.Buffer
+		tab := newTable(&buf)
+		tab.add("a long cell", "x")
+		tab.flush()
+		buf.Reset()
+
+		tab.add("a", "y")
+		tab.flush()
+		if got, want := buf.String(), "a    |y\n"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+}
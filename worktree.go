@@ -0,0 +1,188 @@
+package castor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// worktreePath returns the path of the worktree of PR n, under conf.Worktrees
+// or next to the repository (e.g. ../castor-reviews/pr-42 for ../castor).
+func worktreePath(n int, conf Conf) (string, error) {
+	// the common dir is the .git dir of the main working copy, even when
+	// running from another worktree
+	gitDir, err := git.Output("rev-parse", "--path-format=absolute", "--git-common-dir")
+	if err != nil {
+		return "", err
+	}
+	top := filepath.Dir(gitDir)
+
+	dir := filepath.Join(filepath.Dir(top), filepath.Base(top)+"-reviews")
+	if conf.Worktrees != "" {
+		dir = filepath.Join(conf.Worktrees, filepath.Base(top))
+	}
+
+	return filepath.Join(dir, "pr-"+strconv.Itoa(n)), nil
+}
+
+// tempWorktrees is the dir `castor review --force-worktree` creates worktrees
+// in, under the temp dir so they don't pile up next to the repository.
+func tempWorktrees() string {
+	return filepath.Join(os.TempDir(), "castor-reviews")
+}
+
+// reviewInWorktree checks out PR n in its own worktree, leaving the current
+// working copy untouched. Reviewing the PR again updates the worktree.
+func reviewInWorktree(n int, base, head, ref string, conf Conf) error {
+	if !isRepo() {
+		return fmt.Errorf("Not a git repository")
+	}
+
+	path, err := worktreePath(n, conf)
+	if err != nil {
+		return err
+	}
+	branch := prBranch(n)
+
+	lfs, err := lfsMode(conf)
+	if err != nil {
+		return err
+	}
+	opts := lfsCheckoutArgs(lfs)
+
+	// git refuses to fetch into a branch checked out in another worktree
+	branches, err := checkedOut()
+	if err != nil {
+		return err
+	}
+	if wt, ok := branches[branch]; ok && !samePath(wt, path) {
+		return fmt.Errorf(
+			"PR #%d is checked out in %s, run `castor back` there before reviewing it in a worktree",
+			n,
+			wt,
+		)
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		fmt.Printf("Updating worktree of PR #%d\n\n", n)
+		if err := updatePR(path, conf.Remote, ref, opts); err != nil {
+			return err
+		}
+	} else {
+		if err := fetchPR(conf.Remote, ref, branch); err != nil {
+			return err
+		}
+
+		fmt.Printf("\nCreating worktree of PR #%d\n\n", n)
+		if err := git.RunWithPipe(append(opts, "worktree", "add", path, branch)...); err != nil {
+			return err
+		}
+	}
+	if err := pullLFS(path, conf.Remote, base, branch, lfs); err != nil {
+		fmt.Printf("\nCouldn't fetch the Git LFS files: %s\n", err)
+	}
+
+	fmt.Printf("\nPR #%d (from `%s`) is checked out in:\n\n  cd %s\n", n, head, path)
+
+	if conf.ShowStats {
+		diff, err := statDiff(base, branch)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("\nHere's what changed between %s and %s:\n\n %s\n", base, head, diff)
+	}
+
+	return nil
+}
+
+// removeWorktree removes the worktree of PR n, git refuses to do it if the
+// worktree has changes.
+func removeWorktree(n int, conf Conf) error {
+	if !isRepo() {
+		return fmt.Errorf("Not a git repository")
+	}
+
+	path, err := worktreePath(n, conf)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		// it may have been created by --force-worktree
+		tmp := conf
+		tmp.Worktrees = tempWorktrees()
+		tmpPath, err := worktreePath(n, tmp)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(tmpPath); err != nil {
+			return fmt.Errorf("There's no worktree for PR #%d in %s", n, path)
+		}
+		path = tmpPath
+	}
+
+	fmt.Printf("Removing worktree of PR #%d\n\n", n)
+	if err := git.RunWithPipe("worktree", "remove", path); err != nil {
+		return err
+	}
+
+	return git.Run("worktree", "prune")
+}
+
+// checkedOut returns the branches checked out in the worktrees of the repo,
+// including the main one, mapped to the path of their worktree.
+func checkedOut() (map[string]string, error) {
+	out, err := git.Output("worktree", "list", "--porcelain")
+	if err != nil {
+		return nil, err
+	}
+
+	branches := map[string]string{}
+	var path string
+	for _, line := range strings.Split(out, "\n") {
+		switch {
+		case strings.HasPrefix(line, "worktree "):
+			path = strings.TrimPrefix(line, "worktree ")
+		case strings.HasPrefix(line, "branch refs/heads/"):
+			branches[strings.TrimPrefix(line, "branch refs/heads/")] = path
+		}
+	}
+
+	return branches, nil
+}
+
+// checkedOutElsewhere returns the path of the worktree other than the current
+// one branch is checked out in, if any.
+func checkedOutElsewhere(branch string) (string, bool, error) {
+	branches, err := checkedOut()
+	if err != nil {
+		return "", false, err
+	}
+	path, ok := branches[branch]
+	if !ok {
+		return "", false, nil
+	}
+
+	top, err := git.Output("rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", false, err
+	}
+	if samePath(path, top) {
+		return "", false, nil
+	}
+	return path, true, nil
+}
+
+// samePath tells if the paths a and b are the same dir, resolving symlinks
+// (e.g. /tmp is a symlink in macOS).
+func samePath(a, b string) bool {
+	if ra, err := filepath.EvalSymlinks(a); err == nil {
+		a = ra
+	}
+	if rb, err := filepath.EvalSymlinks(b); err == nil {
+		b = rb
+	}
+	return filepath.Clean(a) == filepath.Clean(b)
+}